@@ -17,7 +17,22 @@ import (
 const envPath = ".env"
 
 type Envs struct {
-	Addr   string `envconfig:"ADDR" required:"true"`
+	Addr          string `envconfig:"ADDR" required:"true"`
+	StreamBatch   uint   `envconfig:"STREAM_BATCH" default:"10"`
+	MaxMessageLen uint32 `envconfig:"MAX_MESSAGE_LEN" default:"65536"`
+	Compression   bool   `envconfig:"COMPRESSION" default:"true"`
+	// Tag limits random phrase to the theme, e.g. motivation
+	Tag string `envconfig:"TAG"`
+	// Languages are preferred languages of phrases, e.g. de,en
+	Languages []string `envconfig:"LANGUAGES"`
+	// PageSize fetches all phrases page by page if it's set
+	PageSize uint `envconfig:"PAGE_SIZE"`
+	Search   struct {
+		// Author and Keyword enable search of phrases if any of them is set
+		Author  string `envconfig:"SEARCH_AUTHOR"`
+		Keyword string `envconfig:"SEARCH_KEYWORD"`
+		Limit   uint   `envconfig:"SEARCH_LIMIT" default:"10"`
+	}
 	Logger struct {
 		Pretty bool          `envconfig:"LOG_PRETTY" default:"false"`
 		Lvl    zerolog.Level `envconfig:"LOG_LVL" default:"debug"`
@@ -48,7 +63,15 @@ func main() {
 
 func run(ctx context.Context, envs Envs) error {
 	log.Info().Str("addr", envs.Addr).Msgf("Connecting to Words of Wisdom")
-	client, err := api.Dial(envs.Addr)
+	opts := []api.ClientOption{
+		api.WithStreamBatch(envs.StreamBatch),
+		api.WithMaxMessageLen(envs.MaxMessageLen),
+		api.WithLanguages(envs.Languages...),
+	}
+	if envs.Compression {
+		opts = append(opts, api.WithCompression())
+	}
+	client, err := api.Dial(envs.Addr, opts...)
 	if err != nil {
 		return err
 	}
@@ -59,15 +82,46 @@ func run(ctx context.Context, envs Envs) error {
 	}()
 
 	log.Info().Msgf("Obtaining random phrase...")
-	res, err := client.Phrase(ctx)
+	res, err := client.Phrase(ctx, api.PhraseRequest{Tag: envs.Tag})
 	if err != nil {
 		return err
 	}
-	log.Info().Str("author", res.Author).Msg(res.Quote)
+	log.Info().Str("id", res.ID).Str("author", res.Author).Strs("tags", res.Tags).Str("language", res.Language).Msg(res.Quote)
 
 	log.Info().Msgf("Obtaining all phrases...")
 	var phrases []api.PhraseResponse
-	for res, err := range client.AllPhrases(ctx) {
+	if envs.PageSize > 0 {
+		for cursor := ""; ; {
+			page, next, err := client.PhrasesPage(ctx, cursor, envs.PageSize)
+			if err != nil {
+				return err
+			}
+			phrases = append(phrases, page...)
+			if cursor = next; cursor == "" {
+				break
+			}
+		}
+	} else {
+		for res, err := range client.AllPhrases(ctx) {
+			if err != nil {
+				return err
+			}
+			phrases = append(phrases, *res)
+		}
+	}
+	log.Info().Int("count", len(phrases)).Interface("phrases", phrases).Send()
+
+	if envs.Search.Author == "" && envs.Search.Keyword == "" {
+		return nil
+	}
+	log.Info().Msgf("Searching phrases...")
+	phrases = phrases[:0]
+	req := api.SearchPhrasesRequest{
+		Author:  envs.Search.Author,
+		Keyword: envs.Search.Keyword,
+		Limit:   envs.Search.Limit,
+	}
+	for res, err := range client.SearchPhrases(ctx, req) {
 		if err != nil {
 			return err
 		}
@@ -2,17 +2,27 @@ package main
 
 import (
 	"context"
+	"crypto/tls"
+	"crypto/x509"
 	_ "embed"
+	"io"
+	"net/netip"
+	"os"
 	"os/signal"
 	"syscall"
 	"time"
 
 	"github.com/egsam98/errors"
+	_ "github.com/jackc/pgx/v5/stdlib"
 	"github.com/rs/zerolog"
 	"github.com/rs/zerolog/log"
 	"golang.org/x/sync/errgroup"
+	_ "modernc.org/sqlite"
 
+	"github.com/egsam98/wow/apps/server/internal/repository"
+	filerepo "github.com/egsam98/wow/apps/server/internal/repository/file"
 	memrepo "github.com/egsam98/wow/apps/server/internal/repository/mem"
+	sqlrepo "github.com/egsam98/wow/apps/server/internal/repository/sql"
 	"github.com/egsam98/wow/apps/server/internal/server"
 	"github.com/egsam98/wow/internal/api"
 	"github.com/egsam98/wow/internal/envconf"
@@ -22,10 +32,86 @@ import (
 const envPath = ".env"
 
 type Envs struct {
-	Addr        string        `envconfig:"ADDR" required:"true"`
-	PuzzleZeros uint          `envconfig:"PUZZLE_ZEROS" required:"true"`
-	TCPTimeout  time.Duration `envconfig:"TCP_TIMEOUT" default:"20s"`
-	Logger      struct {
+	Addr        string `envconfig:"ADDR" required:"true"`
+	PuzzleZeros uint   `envconfig:"PUZZLE_ZEROS" required:"true"`
+	// MaxConns limits concurrent connections of ADDR listener. Zero value means no limit
+	MaxConns int32 `envconfig:"MAX_CONNS"`
+	// TrustedProxies are CIDRs of proxies sending PROXY protocol header, e.g. 10.0.0.0/8,192.168.1.10/32
+	TrustedProxies []netip.Prefix `envconfig:"TRUSTED_PROXIES"`
+	TCP            struct {
+		ReadTimeout  time.Duration `envconfig:"TCP_READ_TIMEOUT" default:"5s"`
+		PoWTimeout   time.Duration `envconfig:"TCP_POW_TIMEOUT" default:"20s"`
+		WriteTimeout time.Duration `envconfig:"TCP_WRITE_TIMEOUT" default:"5s"`
+		IdleTimeout  time.Duration `envconfig:"TCP_IDLE_TIMEOUT" default:"60s"`
+		// KeepaliveTimeout closes connections that send pings only
+		KeepaliveTimeout time.Duration `envconfig:"TCP_KEEPALIVE_TIMEOUT" default:"10m"`
+	}
+	TLS struct {
+		// Addr enables TLS listener if it's set. It shares PoW policy with ADDR listener
+		Addr     string `envconfig:"TLS_ADDR"`
+		CertFile string `envconfig:"TLS_CERT_FILE"`
+		KeyFile  string `envconfig:"TLS_KEY_FILE"`
+		MaxConns int32  `envconfig:"TLS_MAX_CONNS"`
+		// ClientCAFile enables verification of client certificates, see ADMIN_IDENTITIES
+		ClientCAFile string `envconfig:"TLS_CLIENT_CA_FILE"`
+	}
+	Admin struct {
+		// Tokens authorize write operations
+		Tokens []string `envconfig:"ADMIN_TOKENS"`
+		// Identities are common names of TLS client certificates authorizing write operations
+		Identities []string `envconfig:"ADMIN_IDENTITIES"`
+	}
+	Internal struct {
+		// Addr enables listener of internal network that grants access without PoW if it's set
+		Addr     string `envconfig:"INTERNAL_ADDR"`
+		MaxConns int32  `envconfig:"INTERNAL_MAX_CONNS"`
+	}
+	Unix struct {
+		// SocketMode is a file mode of Unix domain socket if ADDR is `unix://<path>`, e.g. 0660
+		SocketMode os.FileMode `envconfig:"UNIX_SOCKET_MODE"`
+		// PoWBypass grants access without PoW to peers connected via Unix domain socket
+		PoWBypass bool `envconfig:"UNIX_POW_BYPASS" default:"false"`
+	}
+	WebSocket struct {
+		// Addr enables WebSocket transport if it's set
+		Addr    string   `envconfig:"WS_ADDR"`
+		Origins []string `envconfig:"WS_ORIGINS"`
+	}
+	Health struct {
+		// Addr enables HTTP server of /livez and /readyz probes if it's set
+		Addr string `envconfig:"HEALTH_ADDR"`
+	}
+	HTTP struct {
+		// Addr enables HTTP gateway if it's set
+		Addr         string        `envconfig:"HTTP_ADDR"`
+		ChallengeTTL time.Duration `envconfig:"HTTP_CHALLENGE_TTL" default:"1m"`
+	}
+	Message struct {
+		RequestLen      uint32 `envconfig:"MESSAGE_REQUEST_LEN" default:"1024"`
+		ResponseLen     uint32 `envconfig:"MESSAGE_RESPONSE_LEN" default:"65536"`
+		AdminRequestLen uint32 `envconfig:"MESSAGE_ADMIN_REQUEST_LEN" default:"65536"`
+		// CompressionThreshold enables compression of larger messages. Zero value disables compression
+		CompressionThreshold uint32 `envconfig:"MESSAGE_COMPRESSION_THRESHOLD" default:"512"`
+	}
+	Repository struct {
+		// File is a path of phrases file (.json, .ndjson, .jsonl, .yaml, .yml or .csv) that is reloaded on changes.
+		// Embedded phrases are served if it's empty
+		File           string        `envconfig:"REPOSITORY_FILE"`
+		ReloadInterval time.Duration `envconfig:"REPOSITORY_RELOAD_INTERVAL" default:"5s"`
+		// RequirePhrases fails start if there are no phrases. Otherwise, server runs degraded responding with not found
+		// errors and reports unreadiness via HEALTH_ADDR until phrases are added. It doesn't apply to SQL repository:
+		// its phrases are added via admin API of the running server
+		RequirePhrases bool `envconfig:"REPOSITORY_REQUIRE_PHRASES" default:"true"`
+		SQL            struct {
+			// DSN enables SQL repository if it's set. It takes precedence over REPOSITORY_FILE
+			DSN string `envconfig:"SQL_DSN"`
+			// Driver is either sqlite (development) or pgx (PostgreSQL)
+			Driver string `envconfig:"SQL_DRIVER" default:"sqlite"`
+			// Seed inserts embedded phrases on start if database has no phrases
+			Seed bool `envconfig:"SQL_SEED" default:"false"`
+		}
+	}
+	Logger struct {
 		Pretty bool          `envconfig:"LOG_PRETTY" default:"false"`
 		Lvl    zerolog.Level `envconfig:"LOG_LVL" default:"debug"`
 	}
@@ -61,30 +147,174 @@ func run(ctx context.Context, envs Envs) error {
 	if err != nil {
 		return err
 	}
-	repo, err := memrepo.NewRepository()
+	repo, watch, err := newRepository(ctx, envs)
 	if err != nil {
 		return err
 	}
+	if closer, ok := repo.(io.Closer); ok {
+		defer closer.Close()
+	}
 
+	listeners := []api.Listener{{Addr: envs.Addr, MaxConns: envs.MaxConns}}
+	if envs.TLS.Addr != "" {
+		cert, err := tls.LoadX509KeyPair(envs.TLS.CertFile, envs.TLS.KeyFile)
+		if err != nil {
+			return errors.Wrap(err, "load TLS certificate")
+		}
+		config := &tls.Config{Certificates: []tls.Certificate{cert}, MinVersion: tls.VersionTLS12}
+		if envs.TLS.ClientCAFile != "" {
+			pem, err := os.ReadFile(envs.TLS.ClientCAFile)
+			if err != nil {
+				return errors.Wrap(err, "read client CA")
+			}
+			config.ClientCAs = x509.NewCertPool()
+			if !config.ClientCAs.AppendCertsFromPEM(pem) {
+				return errors.Errorf("no certificates in %s", envs.TLS.ClientCAFile)
+			}
+			config.ClientAuth = tls.VerifyClientCertIfGiven
+		}
+		listeners = append(listeners, api.Listener{
+			Addr:     envs.TLS.Addr,
+			TLS:      config,
+			MaxConns: envs.TLS.MaxConns,
+		})
+	}
+	if envs.Internal.Addr != "" {
+		listeners = append(listeners, api.Listener{
+			Addr:      envs.Internal.Addr,
+			PoWBypass: true,
+			MaxConns:  envs.Internal.MaxConns,
+		})
+	}
+
+	handler := server.NewHandler(repo)
+	if err := handler.Ready(ctx); err != nil {
+		if envs.Repository.RequirePhrases && envs.Repository.SQL.DSN == "" {
+			return errors.Wrap(err, "check phrases")
+		}
+		log.Warn().Err(err).Msg("Server runs degraded until phrases are added")
+	}
+	opts := []api.ServerOption{
+		api.WithListeners(listeners...),
+		api.WithMessageLimits(api.MessageLimits{
+			Request:      envs.Message.RequestLen,
+			Response:     envs.Message.ResponseLen,
+			AdminRequest: envs.Message.AdminRequestLen,
+		}),
+		api.WithCompressionThreshold(envs.Message.CompressionThreshold),
+		api.WithWebSocketOrigins(envs.WebSocket.Origins...),
+		api.WithUnixSocketMode(envs.Unix.SocketMode),
+		api.WithTrustedProxies(envs.TrustedProxies...),
+	}
+	if len(envs.Admin.Tokens) > 0 || len(envs.Admin.Identities) > 0 {
+		opts = append(opts, api.WithAdmin(api.Admin{
+			Handler:    handler,
+			Tokens:     envs.Admin.Tokens,
+			Identities: envs.Admin.Identities,
+		}))
+	}
+	if envs.Unix.PoWBypass {
+		opts = append(opts, api.WithLocalPoWBypass())
+	}
 	srv := api.NewServer(
-		envs.Addr,
-		envs.TCPTimeout,
-		server.NewHandler(repo),
+		"",
+		api.Timeouts{
+			Read:      envs.TCP.ReadTimeout,
+			PoW:       envs.TCP.PoWTimeout,
+			Write:     envs.TCP.WriteTimeout,
+			Idle:      envs.TCP.IdleTimeout,
+			Keepalive: envs.TCP.KeepaliveTimeout,
+		},
+		handler,
 		puzzle,
+		opts...,
 	)
 	defer srv.Close()
 
 	g, ctx := errgroup.WithContext(ctx)
-	log.Info().
-		Str("addr", envs.Addr).
-		Uint("puzzle_zeros(complexity)", envs.PuzzleZeros).
-		Msg("Listening server")
+	for _, l := range listeners {
+		log.Info().
+			Str("addr", l.Addr).
+			Bool("tls", l.TLS != nil).
+			Bool("pow_bypass", l.PoWBypass).
+			Int32("max_conns", l.MaxConns).
+			Uint("puzzle_zeros(complexity)", envs.PuzzleZeros).
+			Msg("Listening server")
+	}
 	g.Go(func() error { return srv.Listen(ctx) })
 
-	// TODO Healthcheck
+	if watch != nil {
+		g.Go(func() error { return watch(ctx) })
+	}
+
+	if envs.WebSocket.Addr != "" {
+		log.Info().Str("addr", envs.WebSocket.Addr).Msg("Listening WebSocket server")
+		g.Go(func() error { return srv.ListenWebSocket(ctx, envs.WebSocket.Addr) })
+	}
+
+	if envs.HTTP.Addr != "" {
+		gateway, err := api.NewGateway(envs.HTTP.Addr, handler, puzzle, envs.HTTP.ChallengeTTL)
+		if err != nil {
+			return err
+		}
+		log.Info().Str("addr", envs.HTTP.Addr).Msg("Listening HTTP gateway")
+		g.Go(func() error { return gateway.Listen(ctx) })
+	}
+
+	if envs.Health.Addr != "" {
+		log.Info().Str("addr", envs.Health.Addr).Msg("Listening health probes")
+		g.Go(func() error { return api.NewHealth(envs.Health.Addr, handler.Ready).Listen(ctx) })
+	}
 
 	if err := g.Wait(); err != nil && !errors.Is(err, context.Canceled) {
 		return err
 	}
 	return nil
 }
+
+// newRepository creates repository chosen by Envs. Optional `watch` keeps the repository up to date until the context
+// is canceled
+func newRepository(
+	ctx context.Context,
+	envs Envs,
+) (repo repository.Repository, watch func(context.Context) error, err error) {
+	if dsn := envs.Repository.SQL.DSN; dsn != "" {
+		sqlRepo, err := sqlrepo.NewRepository(ctx, envs.Repository.SQL.Driver, dsn)
+		if err != nil {
+			return nil, nil, err
+		}
+		if envs.Repository.SQL.Seed {
+			if err := seed(ctx, sqlRepo); err != nil {
+				_ = sqlRepo.Close()
+				return nil, nil, err
+			}
+		}
+		log.Info().Str("driver", envs.Repository.SQL.Driver).Msg("Phrases are stored in SQL database")
+		return sqlRepo, nil, nil
+	}
+	if path := envs.Repository.File; path != "" {
+		fileRepo, err := filerepo.NewRepository(path)
+		if err != nil {
+			return nil, nil, err
+		}
+		log.Info().Str("path", path).Msg("Phrases are loaded from file")
+		return fileRepo, func(ctx context.Context) error {
+			return fileRepo.Watch(ctx, envs.Repository.ReloadInterval)
+		}, nil
+	}
+	memRepo, err := memrepo.NewRepository()
+	return memRepo, nil, err
+}
+
+// seed SQL repository with embedded phrases
+func seed(ctx context.Context, repo *sqlrepo.Repository) error {
+	memRepo, err := memrepo.NewRepository()
+	if err != nil {
+		return err
+	}
+	phrases, _, err := memRepo.AllPhrases(ctx, repository.Page{})
+	if err != nil {
+		return err
+	}
+	return repo.Seed(ctx, phrases)
+}
@@ -0,0 +1,175 @@
+package file
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/egsam98/errors"
+	"github.com/rs/zerolog/log"
+	"gopkg.in/yaml.v3"
+
+	"github.com/egsam98/wow/apps/server/internal/repository"
+	"github.com/egsam98/wow/apps/server/internal/repository/mem"
+)
+
+// Repository serves phrases loaded from file and reloads them once the file is changed.
+// Format is chosen by extension:
+// - .json: array of phrases
+// - .ndjson, .jsonl: phrase per line
+// - .yaml, .yml: list of phrases
+// - .csv: header with `quote`, `author` and optional `id`, `tags`, `language` columns followed by phrase per row.
+// Tags are separated by semicolon, translations aren't supported
+//
+// Invalid file doesn't replace the served phrases. The file is the only source of phrases, so mutations fail with
+// repository.ErrReadOnly
+type Repository struct {
+	*mem.Repository
+	path string
+	// modTime and size of the loaded file detect changes
+	modTime time.Time
+	size    int64
+}
+
+// NewRepository loads phrases from file at `path`.
+// Errors:
+// - repository.ErrInvalidPhrase if file contains invalid phrases
+func NewRepository(path string) (*Repository, error) {
+	r := Repository{
+		Repository: new(mem.Repository),
+		path:       path,
+	}
+	if err := r.reload(); err != nil {
+		return nil, err
+	}
+	return &r, nil
+}
+
+func (r *Repository) CreatePhrase(context.Context, repository.Phrase) (*repository.Phrase, error) {
+	return nil, errors.Wrap(repository.ErrReadOnly, "edit %s instead", r.path)
+}
+
+func (r *Repository) UpdatePhrase(context.Context, repository.Phrase) (*repository.Phrase, error) {
+	return nil, errors.Wrap(repository.ErrReadOnly, "edit %s instead", r.path)
+}
+
+func (r *Repository) DeletePhrase(context.Context, string) error {
+	return errors.Wrap(repository.ErrReadOnly, "edit %s instead", r.path)
+}
+
+// Watch checks file for changes every `interval` reloading phrases. Failed reloads are logged.
+// The method blocks until the context is canceled
+func (r *Repository) Watch(ctx context.Context, interval time.Duration) error {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+
+		info, err := os.Stat(r.path)
+		if err != nil {
+			log.Err(err).Str("path", r.path).Msg("Stat phrases file")
+			continue
+		}
+		if info.ModTime().Equal(r.modTime) && info.Size() == r.size {
+			continue
+		}
+		if err := r.reload(); err != nil {
+			log.Error().Stack().Err(err).Str("path", r.path).Msg("Reload phrases file, the previous version is kept")
+			continue
+		}
+		log.Info().Str("path", r.path).Msg("Phrases file is reloaded")
+	}
+}
+
+func (r *Repository) reload() error {
+	f, err := os.Open(r.path)
+	if err != nil {
+		return errors.Wrap(err, "open %s", r.path)
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return errors.Wrap(err, "stat %s", r.path)
+	}
+	// File is considered seen even if it's invalid, so that it isn't reloaded until the next change
+	r.modTime, r.size = info.ModTime(), info.Size()
+
+	phrases, err := decode(f, filepath.Ext(r.path))
+	if err != nil {
+		return errors.Wrap(err, "decode %s", r.path)
+	}
+	return r.Replace(phrases)
+}
+
+// decode phrases according to file extension
+func decode(r io.Reader, ext string) ([]repository.Phrase, error) {
+	var phrases []repository.Phrase
+	switch strings.ToLower(ext) {
+	case ".json":
+		if err := json.NewDecoder(r).Decode(&phrases); err != nil {
+			return nil, errors.Wrap(err, "decode JSON")
+		}
+	case ".ndjson", ".jsonl":
+		dec := json.NewDecoder(r)
+		for {
+			var phrase repository.Phrase
+			if err := dec.Decode(&phrase); err != nil {
+				if errors.Is(err, io.EOF) {
+					break
+				}
+				return nil, errors.Wrap(err, "decode NDJSON line #%d", len(phrases)+1)
+			}
+			phrases = append(phrases, phrase)
+		}
+	case ".yaml", ".yml":
+		if err := yaml.NewDecoder(r).Decode(&phrases); err != nil && !errors.Is(err, io.EOF) {
+			return nil, errors.Wrap(err, "decode YAML")
+		}
+	case ".csv":
+		return decodeCSV(r)
+	default:
+		return nil, errors.Errorf("unsupported file extension %q", ext)
+	}
+	return phrases, nil
+}
+
+func decodeCSV(r io.Reader) ([]repository.Phrase, error) {
+	records, err := csv.NewReader(r).ReadAll()
+	if err != nil {
+		return nil, errors.Wrap(err, "read CSV")
+	}
+	if len(records) == 0 {
+		return nil, errors.New("CSV header is missing")
+	}
+	header := records[0]
+	quote, author := slices.Index(header, "quote"), slices.Index(header, "author")
+	id, tags, lang := slices.Index(header, "id"), slices.Index(header, "tags"), slices.Index(header, "language")
+	if quote < 0 || author < 0 {
+		return nil, errors.Errorf("CSV header %v must contain quote and author", header)
+	}
+
+	phrases := make([]repository.Phrase, len(records)-1)
+	for i, record := range records[1:] {
+		phrases[i] = repository.Phrase{Quote: record[quote], Author: record[author]}
+		if id >= 0 {
+			phrases[i].ID = record[id]
+		}
+		if tags >= 0 && record[tags] != "" {
+			phrases[i].Tags = strings.Split(record[tags], ";")
+		}
+		if lang >= 0 {
+			phrases[i].Language = record[lang]
+		}
+	}
+	return phrases, nil
+}
@@ -0,0 +1,90 @@
+package file
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/egsam98/wow/apps/server/internal/repository"
+)
+
+func TestNewRepository(t *testing.T) {
+	ctx := context.Background()
+	exp := []repository.Phrase{
+		{ID: "1", Quote: "q1", Author: "a1"},
+		{ID: repository.PhraseID("q2", "a2"), Quote: "q2", Author: "a2", Tags: []string{"t1", "t2"}},
+	}
+	for _, tc := range []struct {
+		name    string
+		content string
+	}{
+		{name: "phrases.json", content: `[{"id": "1", "quote": "q1", "author": "a1"}, {"quote": "q2", "author": "a2", "tags": ["t1", "t2"]}]`},
+		{name: "phrases.ndjson", content: "{\"id\": \"1\", \"quote\": \"q1\", \"author\": \"a1\"}\n{\"quote\": \"q2\", \"author\": \"a2\", \"tags\": [\"t1\", \"t2\"]}\n"},
+		{name: "phrases.yaml", content: "- id: \"1\"\n  quote: q1\n  author: a1\n- quote: q2\n  author: a2\n  tags: [t1, t2]\n"},
+		{name: "phrases.csv", content: "author,quote,id,tags,language\na1,q1,1,,\na2,q2,,t1;t2,\n"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			repo, err := NewRepository(writeFile(t, filepath.Join(t.TempDir(), tc.name), tc.content))
+			require.NoError(t, err)
+			phrases, _, err := repo.AllPhrases(ctx, repository.Page{})
+			require.NoError(t, err)
+			assert.Equal(t, exp, phrases)
+		})
+	}
+
+	t.Run("invalid phrase", func(t *testing.T) {
+		_, err := NewRepository(writeFile(t, filepath.Join(t.TempDir(), "phrases.json"), `[{"quote": "q1"}]`))
+		require.ErrorIs(t, err, repository.ErrInvalidPhrase)
+	})
+
+	t.Run("read-only", func(t *testing.T) {
+		repo, err := NewRepository(writeFile(t, filepath.Join(t.TempDir(), "phrases.json"), `[{"id": "1", "quote": "q1", "author": "a1"}]`))
+		require.NoError(t, err)
+		_, err = repo.CreatePhrase(ctx, repository.Phrase{Quote: "q2", Author: "a2"})
+		require.ErrorIs(t, err, repository.ErrReadOnly)
+		_, err = repo.UpdatePhrase(ctx, repository.Phrase{ID: "1", Quote: "q2", Author: "a2"})
+		require.ErrorIs(t, err, repository.ErrReadOnly)
+		require.ErrorIs(t, repo.DeletePhrase(ctx, "1"), repository.ErrReadOnly)
+		phrases, _, err := repo.AllPhrases(ctx, repository.Page{})
+		require.NoError(t, err)
+		assert.Equal(t, []repository.Phrase{{ID: "1", Quote: "q1", Author: "a1"}}, phrases)
+	})
+}
+
+func TestRepository_Watch(t *testing.T) {
+	path := writeFile(t, filepath.Join(t.TempDir(), "phrases.json"), `[{"quote": "q1", "author": "a1"}]`)
+	repo, err := NewRepository(path)
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- repo.Watch(ctx, 10*time.Millisecond) }()
+	defer func() {
+		cancel()
+		assert.NoError(t, <-done)
+	}()
+
+	// Invalid file is ignored
+	writeFile(t, path, `[{"quote": "", "author": "a2"}]`)
+	time.Sleep(50 * time.Millisecond)
+	phrase, err := repo.Phrase(ctx, repository.Filter{})
+	require.NoError(t, err)
+	assert.Equal(t, "q1", phrase.Quote)
+
+	writeFile(t, path, `[{"quote": "q3", "author": "a3"}]`)
+	require.Eventually(t, func() bool {
+		phrase, err := repo.Phrase(ctx, repository.Filter{})
+		return err == nil && phrase.Quote == "q3"
+	}, time.Second, 10*time.Millisecond)
+}
+
+func writeFile(t *testing.T, path, content string) string {
+	t.Helper()
+	require.NoError(t, os.WriteFile(path, []byte(content), 0o600))
+	return path
+}
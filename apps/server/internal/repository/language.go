@@ -0,0 +1,62 @@
+package repository
+
+import (
+	"strings"
+)
+
+// maxLanguageLen is the longest BCP 47 tag in practice, see RFC 5646 section 4.4.1
+const maxLanguageLen = 35
+
+// Localize returns phrase in the first of preferred `languages` it's available in, the phrase in its original language
+// is returned otherwise. Languages are BCP 47 tags (e.g. "pt-BR") in order of preference, they're matched
+// case-insensitively via lookup of RFC 4647: every tag is truncated subtag by subtag until it matches, e.g. "pt-BR"
+// matches "pt-BR" and then "pt", but not "pt-PT". Translations of the result are dropped
+func (p Phrase) Localize(languages []string) Phrase {
+	localized := p.Clone()
+	localized.Translations = nil
+	for _, preferred := range languages {
+		for lang := preferred; lang != ""; lang = truncateLanguage(lang) {
+			if strings.EqualFold(lang, p.Language) {
+				return localized
+			}
+			// Languages of translations are unique case-insensitively, see Phrase.Validate
+			for translated, translation := range p.Translations {
+				if strings.EqualFold(lang, translated) {
+					localized.Language, localized.Quote, localized.Author = translated, translation.Quote, translation.Author
+					return localized
+				}
+			}
+		}
+	}
+	return localized
+}
+
+// truncateLanguage removes the last subtag of BCP 47 tag, e.g. "zh-Hant-TW" -> "zh-Hant"
+func truncateLanguage(lang string) string {
+	i := strings.LastIndexByte(lang, '-')
+	if i < 0 {
+		return ""
+	}
+	return lang[:i]
+}
+
+// validLanguage checks syntax of BCP 47 tag: alphanumeric subtags of 1-8 characters separated by hyphen, the first
+// subtag is a letter-only language
+func validLanguage(lang string) bool {
+	if lang == "" || len(lang) > maxLanguageLen {
+		return false
+	}
+	for i, subtag := range strings.Split(lang, "-") {
+		if subtag == "" || len(subtag) > 8 {
+			return false
+		}
+		for _, c := range subtag {
+			letter := c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z'
+			digit := c >= '0' && c <= '9'
+			if !letter && (i == 0 || !digit) {
+				return false
+			}
+		}
+	}
+	return true
+}
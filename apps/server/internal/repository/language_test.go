@@ -0,0 +1,60 @@
+package repository
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPhrase_Localize(t *testing.T) {
+	phrase := Phrase{
+		ID:       "1",
+		Quote:    "Less is more",
+		Author:   "Mies",
+		Tags:     []string{"minimalism"},
+		Language: "en",
+		Translations: map[string]Translation{
+			"de":    {Quote: "Weniger ist mehr", Author: "Mies"},
+			"pt-PT": {Quote: "Menos é mais", Author: "Mies"},
+		},
+	}
+	for _, tc := range []struct {
+		languages []string
+		exp       string
+	}{
+		{languages: nil, exp: "en"},
+		{languages: []string{"fr"}, exp: "en"},
+		{languages: []string{"DE"}, exp: "de"},
+		{languages: []string{"de-AT", "en"}, exp: "de"},
+		{languages: []string{"en-US", "de"}, exp: "en"},
+		{languages: []string{"pt-BR", "de"}, exp: "de"},
+		{languages: []string{"pt-PT-x-custom"}, exp: "pt-PT"},
+	} {
+		localized := phrase.Localize(tc.languages)
+		assert.Equal(t, tc.exp, localized.Language, "languages %v", tc.languages)
+		assert.Nil(t, localized.Translations)
+		assert.Equal(t, phrase.Tags, localized.Tags)
+		if tc.exp != "en" {
+			assert.Equal(t, phrase.Translations[tc.exp].Quote, localized.Quote)
+		}
+	}
+}
+
+func TestPhrase_Validate(t *testing.T) {
+	valid := Phrase{Quote: "q", Author: "a", Language: "en", Translations: map[string]Translation{
+		"zh-Hant-TW": {Quote: "q", Author: "a"},
+	}}
+	assert.NoError(t, valid.Validate())
+
+	for _, phrase := range []Phrase{
+		{Quote: " ", Author: "a"},
+		{Quote: "q", Author: "a", Tags: []string{"t", "T"}},
+		{Quote: "q", Author: "a", Language: "e n"},
+		{Quote: "q", Author: "a", Language: "1en"},
+		{Quote: "q", Author: "a", Translations: map[string]Translation{"": {Quote: "q", Author: "a"}}},
+		{Quote: "q", Author: "a", Translations: map[string]Translation{"de": {Quote: "q"}}},
+		{Quote: "q", Author: "a", Language: "de", Translations: map[string]Translation{"DE": {Quote: "q", Author: "a"}}},
+	} {
+		assert.ErrorIs(t, phrase.Validate(), ErrInvalidPhrase, "%+v", phrase)
+	}
+}
@@ -0,0 +1,94 @@
+package mem
+
+import (
+	"iter"
+	"math/rand/v2"
+	"slices"
+	"strings"
+
+	"github.com/egsam98/wow/apps/server/internal/repository"
+)
+
+// dataset is an immutable snapshot of phrases with indexes built at load time
+type dataset struct {
+	phrases []repository.Phrase
+	// quotes are lower-cased quotes of phrases for case-insensitive search
+	quotes []string
+	// byAuthor indexes phrases by lower-cased author
+	byAuthor map[string][]int
+	// byTag indexes phrases by lower-cased tag
+	byTag map[string][]int
+	byID  map[string]int
+	// byContent indexes phrases by PhraseID of their content, it differs from ID of updated phrases
+	byContent map[string]int
+	// sorted are indexes of phrases in order of IDs, see dataset.page
+	sorted []int
+}
+
+func newDataset(phrases []repository.Phrase) *dataset {
+	d := dataset{
+		phrases:   phrases,
+		quotes:    make([]string, len(phrases)),
+		byAuthor:  make(map[string][]int),
+		byTag:     make(map[string][]int),
+		byID:      make(map[string]int, len(phrases)),
+		byContent: make(map[string]int, len(phrases)),
+		sorted:    make([]int, len(phrases)),
+	}
+	for i, phrase := range phrases {
+		d.sorted[i] = i
+		d.byID[phrase.ID] = i
+		d.byContent[repository.PhraseID(phrase.Quote, phrase.Author)] = i
+		d.quotes[i] = strings.ToLower(phrase.Quote)
+		author := strings.ToLower(phrase.Author)
+		d.byAuthor[author] = append(d.byAuthor[author], i)
+		// Tags are unique per phrase, see repository.Phrase.Validate
+		for _, tag := range phrase.Tags {
+			tag = strings.ToLower(tag)
+			d.byTag[tag] = append(d.byTag[tag], i)
+		}
+	}
+	slices.SortFunc(d.sorted, func(a, b int) int { return strings.Compare(phrases[a].ID, phrases[b].ID) })
+	return &d
+}
+
+// after returns indexes of phrases with IDs greater than `id` in order of IDs
+func (d *dataset) after(id string) []int {
+	from, _ := slices.BinarySearchFunc(d.sorted, id, func(i int, id string) int {
+		if d.phrases[i].ID <= id {
+			return -1
+		}
+		return 1
+	})
+	return d.sorted[from:]
+}
+
+// pick returns index of random phrase with the tag. Empty tag matches all phrases.
+// False is returned if there are no such phrases
+func (d *dataset) pick(tag string) (int, bool) {
+	if tag == "" {
+		if len(d.phrases) == 0 {
+			return 0, false
+		}
+		return rand.IntN(len(d.phrases)), true
+	}
+	tagged := d.byTag[strings.ToLower(tag)]
+	if len(tagged) == 0 {
+		return 0, false
+	}
+	return tagged[rand.IntN(len(tagged))], true
+}
+
+// candidates iterates indexes of phrases by author. Empty author matches all phrases
+func (d *dataset) candidates(author string) iter.Seq[int] {
+	if author != "" {
+		return slices.Values(d.byAuthor[strings.ToLower(author)])
+	}
+	return func(yield func(int) bool) {
+		for i := range d.phrases {
+			if !yield(i) {
+				return
+			}
+		}
+	}
+}
@@ -1,9 +1,13 @@
 package mem
 
 import (
+	"context"
 	_ "embed"
+	"encoding/base64"
 	"encoding/json"
-	"math/rand/v2"
+	"slices"
+	"strings"
+	"sync"
 
 	"github.com/egsam98/errors"
 
@@ -13,27 +17,164 @@ import (
 //go:embed phrases.json
 var phrases json.RawMessage
 
+// Repository keeps phrases in memory. Zero value must be filled via Repository.Replace before use
 type Repository struct {
-	// sync.RWMutex isn't necessary for read-only repository
-	phrases []repository.Phrase
+	mu   sync.RWMutex
+	data *dataset
 }
 
+// NewRepository loads embedded phrases.json
 func NewRepository() (*Repository, error) {
+	var list []repository.Phrase
+	if err := json.Unmarshal(phrases, &list); err != nil {
+		return nil, errors.Wrap(err, "unmarshal %s into %T", phrases, list)
+	}
 	var self Repository
-	if err := json.Unmarshal(phrases, &self.phrases); err != nil {
-		return nil, errors.Wrap(err, "unmarshal %s into %T", phrases, self.phrases)
+	if err := self.Replace(list); err != nil {
+		return nil, err
 	}
 	return &self, nil
 }
 
-func (r *Repository) Phrase() (*repository.Phrase, error) {
-	i := rand.IntN(len(r.phrases))
-	copied := r.phrases[i]
+// Replace validates phrases and atomically swaps the whole dataset with them. Missing IDs are derived via
+// repository.PhraseID. The current dataset is kept on error.
+// Errors:
+// - repository.ErrInvalidPhrase if any phrase is invalid or IDs are duplicated
+func (r *Repository) Replace(phrases []repository.Phrase) error {
+	phrases = slices.Clone(phrases)
+	ids := make(map[string]struct{}, len(phrases))
+	for i := range phrases {
+		phrases[i] = phrases[i].Clone()
+		phrase := &phrases[i]
+		if err := phrase.Validate(); err != nil {
+			return errors.Wrap(err, "phrase #%d", i)
+		}
+		if phrase.ID == "" {
+			phrase.ID = repository.PhraseID(phrase.Quote, phrase.Author)
+		}
+		if _, ok := ids[phrase.ID]; ok {
+			return errors.Wrap(repository.ErrInvalidPhrase, "phrase #%d: duplicated ID %s", i, phrase.ID)
+		}
+		ids[phrase.ID] = struct{}{}
+	}
+
+	data := newDataset(phrases)
+	r.mu.Lock()
+	r.data = data
+	r.mu.Unlock()
+	return nil
+}
+
+func (r *Repository) Phrase(_ context.Context, filter repository.Filter) (*repository.Phrase, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	i, ok := r.data.pick(filter.Tag)
+	if !ok {
+		return nil, errors.Wrap(repository.ErrEmpty, "tag %q", filter.Tag)
+	}
+	copied := r.data.phrases[i].Clone()
 	return &copied, nil
 }
 
-func (r *Repository) AllPhrases() ([]repository.Phrase, error) {
-	copied := make([]repository.Phrase, len(r.phrases))
-	copy(copied, r.phrases)
-	return copied, nil
+func (r *Repository) GetPhrase(_ context.Context, id string) (*repository.Phrase, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	i, ok := r.data.byID[id]
+	if !ok {
+		return nil, errors.Wrap(repository.ErrNotFound, "ID %s", id)
+	}
+	copied := r.data.phrases[i].Clone()
+	return &copied, nil
+}
+
+// AllPhrases paginates phrases in order of IDs. Cursor is the last ID of the previous page, so that pages are
+// consistent while the dataset is changed
+func (r *Repository) AllPhrases(_ context.Context, page repository.Page) ([]repository.Phrase, string, error) {
+	after, err := base64.RawURLEncoding.DecodeString(page.Cursor)
+	if err != nil {
+		return nil, "", errors.Wrap(repository.ErrInvalidCursor, "%q", page.Cursor)
+	}
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	indexes := r.data.after(string(after))
+	var next string
+	if page.Size > 0 && len(indexes) > page.Size {
+		indexes = indexes[:page.Size]
+		next = base64.RawURLEncoding.EncodeToString([]byte(r.data.phrases[indexes[len(indexes)-1]].ID))
+	}
+	phrases := make([]repository.Phrase, len(indexes))
+	for i, idx := range indexes {
+		phrases[i] = r.data.phrases[idx].Clone()
+	}
+	return phrases, next, nil
+}
+
+func (r *Repository) SearchPhrases(_ context.Context, query repository.Query) ([]repository.Phrase, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	keyword := strings.ToLower(query.Keyword)
+	var found []repository.Phrase
+	for i := range r.data.candidates(query.Author) {
+		if query.Limit > 0 && len(found) >= query.Limit {
+			break
+		}
+		if strings.Contains(r.data.quotes[i], keyword) {
+			found = append(found, r.data.phrases[i].Clone())
+		}
+	}
+	return found, nil
+}
+
+// Mutations rebuild dataset entirely: they're rare admin operations unlike reads
+
+func (r *Repository) CreatePhrase(_ context.Context, phrase repository.Phrase) (*repository.Phrase, error) {
+	if err := phrase.Validate(); err != nil {
+		return nil, err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if j, ok := r.data.byContent[repository.PhraseID(phrase.Quote, phrase.Author)]; ok {
+		return nil, errors.Wrap(repository.ErrAlreadyExists, "phrase %s", r.data.phrases[j].ID)
+	}
+	for id := range repository.PhraseIDs(phrase.Quote, phrase.Author) {
+		if _, ok := r.data.byID[id]; !ok {
+			phrase.ID = id
+			break
+		}
+	}
+	r.data = newDataset(append(slices.Clip(r.data.phrases), phrase.Clone()))
+	return &phrase, nil
+}
+
+func (r *Repository) UpdatePhrase(_ context.Context, phrase repository.Phrase) (*repository.Phrase, error) {
+	if err := phrase.Validate(); err != nil {
+		return nil, err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	i, ok := r.data.byID[phrase.ID]
+	if !ok {
+		return nil, errors.Wrap(repository.ErrNotFound, "ID %s", phrase.ID)
+	}
+	if j, ok := r.data.byContent[repository.PhraseID(phrase.Quote, phrase.Author)]; ok && j != i {
+		return nil, errors.Wrap(repository.ErrAlreadyExists, "phrase %s", r.data.phrases[j].ID)
+	}
+	phrases := slices.Clone(r.data.phrases)
+	phrases[i] = phrase.Clone()
+	r.data = newDataset(phrases)
+	return &phrase, nil
+}
+
+func (r *Repository) DeletePhrase(_ context.Context, id string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	i, ok := r.data.byID[id]
+	if !ok {
+		return errors.Wrap(repository.ErrNotFound, "ID %s", id)
+	}
+	r.data = newDataset(slices.Delete(slices.Clone(r.data.phrases), i, i+1))
+	return nil
 }
@@ -0,0 +1,28 @@
+package mem
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/egsam98/wow/apps/server/internal/repository"
+	"github.com/egsam98/wow/apps/server/internal/repository/repositorytest"
+)
+
+func TestRepository(t *testing.T) {
+	repositorytest.Run(t, func(t *testing.T, phrases []repository.Phrase) repository.Repository {
+		var repo Repository
+		require.NoError(t, repo.Replace(phrases))
+		return &repo
+	})
+}
+
+func TestNewRepository(t *testing.T) {
+	ctx := context.Background()
+	repo, err := NewRepository()
+	require.NoError(t, err)
+	phrases, _, err := repo.AllPhrases(ctx, repository.Page{})
+	require.NoError(t, err)
+	require.NotEmpty(t, phrases)
+}
@@ -1,14 +1,213 @@
 package repository
 
-// Repository provides access to phrases in database
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"iter"
+	"maps"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/egsam98/errors"
+)
+
+// Limits of phrase's content in runes
+const (
+	MaxQuoteLen  = 1000
+	MaxAuthorLen = 100
+	MaxTagLen    = 50
+)
+
+var (
+	// ErrInvalidCursor is returned for cursor that wasn't issued by the repository
+	ErrInvalidCursor = errors.New("invalid cursor")
+	// ErrNotFound is returned if requested phrase doesn't exist
+	ErrNotFound = errors.New("phrase is not found")
+	// ErrEmpty is returned on picking phrase from repository without phrases matching Filter
+	ErrEmpty = errors.New("no phrases")
+	// ErrInvalidPhrase is returned if phrase doesn't pass Phrase.Validate
+	ErrInvalidPhrase = errors.New("invalid phrase")
+	// ErrAlreadyExists is returned if quote and author of phrase are the same as of another phrase
+	ErrAlreadyExists = errors.New("phrase already exists")
+	// ErrReadOnly is returned on mutating repository whose phrases are managed elsewhere (e.g. in file)
+	ErrReadOnly = errors.New("repository is read-only")
+)
+
+// Repository provides access to phrases in database. Context limits the access, e.g. by deadline of request
 type Repository interface {
-	Phrase() (*Phrase, error)
-	AllPhrases() ([]Phrase, error)
+	// Phrase returns phrase picked uniformly at random among phrases matching the filter.
+	// Errors:
+	// - ErrEmpty
+	Phrase(ctx context.Context, filter Filter) (*Phrase, error)
+	// GetPhrase returns phrase by its ID.
+	// Errors:
+	// - ErrNotFound
+	GetPhrase(ctx context.Context, id string) (*Phrase, error)
+	// AllPhrases returns page of phrases in stable order and cursor of the next page. Empty cursor means the last page.
+	// Errors:
+	// - ErrInvalidCursor
+	AllPhrases(ctx context.Context, page Page) ([]Phrase, string, error)
+	// SearchPhrases returns phrases matching all non-empty filters of the query
+	SearchPhrases(ctx context.Context, query Query) ([]Phrase, error)
+	// CreatePhrase adds phrase assigning its ID, see PhraseIDs. Quote and author are unique among phrases.
+	// Errors:
+	// - ErrInvalidPhrase
+	// - ErrAlreadyExists
+	// - ErrReadOnly
+	CreatePhrase(ctx context.Context, phrase Phrase) (*Phrase, error)
+	// UpdatePhrase replaces content of the phrase with the same ID. The ID is kept even if it was derived from the
+	// previous content. Quote and author are unique among phrases.
+	// Errors:
+	// - ErrInvalidPhrase
+	// - ErrNotFound
+	// - ErrAlreadyExists
+	// - ErrReadOnly
+	UpdatePhrase(ctx context.Context, phrase Phrase) (*Phrase, error)
+	// DeletePhrase deletes phrase by its ID.
+	// Errors:
+	// - ErrNotFound
+	// - ErrReadOnly
+	DeletePhrase(ctx context.Context, id string) error
 }
 
 // DTOs
 
 type Phrase struct {
+	// ID is a stable identifier of the phrase, see PhraseID
+	ID     string `json:"id"`
 	Quote  string `json:"quote"`
 	Author string `json:"author"`
+	// Tags are themes of the phrase (e.g. motivation, humor), they're matched case-insensitively
+	Tags []string `json:"tags,omitempty"`
+	// Language is BCP 47 tag of quote and author, e.g. "en". Empty language is undetermined
+	Language string `json:"language,omitempty"`
+	// Translations of the phrase keyed by BCP 47 tag, see Phrase.Localize
+	Translations map[string]Translation `json:"translations,omitempty"`
+}
+
+// Translation is a phrase in another language
+type Translation struct {
+	Quote  string `json:"quote"`
+	Author string `json:"author"`
+}
+
+// Clone copies phrase including its tags and translations
+func (p Phrase) Clone() Phrase {
+	p.Tags = slices.Clone(p.Tags)
+	p.Translations = maps.Clone(p.Translations)
+	return p
+}
+
+// Validate checks that quote and author aren't blank and fit MaxQuoteLen and MaxAuthorLen, tags aren't blank,
+// duplicated and fit MaxTagLen. The same applies to translations, their languages must be valid and differ from the
+// phrase's one case-insensitively.
+// Errors:
+// - ErrInvalidPhrase
+func (p *Phrase) Validate() error {
+	if err := validateText(p.Quote, p.Author); err != nil {
+		return err
+	}
+	tags := make(map[string]struct{}, len(p.Tags))
+	for _, tag := range p.Tags {
+		if strings.TrimSpace(tag) == "" {
+			return errors.Wrap(ErrInvalidPhrase, "tag is empty")
+		}
+		if utf8.RuneCountInString(tag) > MaxTagLen {
+			return errors.Wrap(ErrInvalidPhrase, "tag is longer than %d characters", MaxTagLen)
+		}
+		lower := strings.ToLower(tag)
+		if _, ok := tags[lower]; ok {
+			return errors.Wrap(ErrInvalidPhrase, "tag %s is duplicated", tag)
+		}
+		tags[lower] = struct{}{}
+	}
+
+	if p.Language != "" && !validLanguage(p.Language) {
+		return errors.Wrap(ErrInvalidPhrase, "invalid language %q", p.Language)
+	}
+	languages := map[string]struct{}{strings.ToLower(p.Language): {}}
+	for lang, translation := range p.Translations {
+		if !validLanguage(lang) {
+			return errors.Wrap(ErrInvalidPhrase, "invalid language %q of translation", lang)
+		}
+		lower := strings.ToLower(lang)
+		if _, ok := languages[lower]; ok {
+			return errors.Wrap(ErrInvalidPhrase, "language %s is duplicated", lang)
+		}
+		languages[lower] = struct{}{}
+		if err := validateText(translation.Quote, translation.Author); err != nil {
+			return errors.Wrap(err, "translation %s", lang)
+		}
+	}
+	return nil
+}
+
+func validateText(quote, author string) error {
+	for _, field := range []struct {
+		name  string
+		value string
+		max   int
+	}{
+		{name: "quote", value: quote, max: MaxQuoteLen},
+		{name: "author", value: author, max: MaxAuthorLen},
+	} {
+		if strings.TrimSpace(field.value) == "" {
+			return errors.Wrap(ErrInvalidPhrase, "%s is empty", field.name)
+		}
+		if utf8.RuneCountInString(field.value) > field.max {
+			return errors.Wrap(ErrInvalidPhrase, "%s is longer than %d characters", field.name, field.max)
+		}
+	}
+	return nil
+}
+
+// PhraseID derives ID from content of the phrase, so that the ID survives reloads of dataset
+func PhraseID(quote, author string) string {
+	h := sha256.New()
+	h.Write([]byte(author))
+	h.Write([]byte{0})
+	h.Write([]byte(quote))
+	return hex.EncodeToString(h.Sum(nil)[:8])
+}
+
+// PhraseIDs yields PhraseID followed by its alternatives. Created phrase takes the first free one: PhraseID may be
+// kept by phrase, whose content was changed via UpdatePhrase
+func PhraseIDs(quote, author string) iter.Seq[string] {
+	return func(yield func(string) bool) {
+		if !yield(PhraseID(quote, author)) {
+			return
+		}
+		for i := 1; ; i++ {
+			if !yield(PhraseID(quote, author+"\x00"+strconv.Itoa(i))) {
+				return
+			}
+		}
+	}
+}
+
+// Filter narrows random selection of phrase. Empty fields match any phrase
+type Filter struct {
+	// Tag matches one of phrase's tags case-insensitively
+	Tag string
+}
+
+// Query filters phrases. Empty fields match any phrase
+type Query struct {
+	// Author matches author's name case-insensitively
+	Author string
+	// Keyword matches substring of quote case-insensitively
+	Keyword string
+	// Limit limits number of phrases. Zero value means no limit
+	Limit int
+}
+
+// Page selects part of phrases
+type Page struct {
+	// Cursor is an opaque position returned with the previous page. Empty cursor means the first page
+	Cursor string
+	// Size limits number of phrases. Zero value means no limit
+	Size int
 }
@@ -0,0 +1,306 @@
+// Package repositorytest provides conformance tests of repository.Repository contract
+package repositorytest
+
+import (
+	"context"
+	"maps"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/egsam98/wow/apps/server/internal/repository"
+)
+
+// Factory creates repository filled with `phrases`. Missing IDs of phrases are expected to be derived via
+// repository.PhraseID
+type Factory func(t *testing.T, phrases []repository.Phrase) repository.Repository
+
+// Phrases are the dataset that every test except empty dataset one starts with
+var Phrases = []repository.Phrase{
+	{
+		ID:       "1",
+		Quote:    "Less is more",
+		Author:   "Mies",
+		Tags:     []string{"minimalism"},
+		Language: "en",
+		Translations: map[string]repository.Translation{
+			"de": {Quote: "Weniger ist mehr", Author: "Mies"},
+			"ru": {Quote: "Меньше значит больше", Author: "Мис"},
+		},
+	},
+	{ID: "2", Quote: "100% of the shots you don't take", Author: "Gretzky", Tags: []string{"Motivation", "sport"}},
+	{ID: "3", Quote: "More or less", Author: "mies", Tags: []string{"minimalism", "humor"}},
+	{ID: "4", Quote: "Stay hungry, stay foolish", Author: "Jobs", Tags: []string{"motivation"}},
+	{ID: "5", Quote: "Simplicity is the ultimate sophistication", Author: "da Vinci"},
+}
+
+// Run runs conformance tests against repository created by `factory`. Every subtest gets a fresh repository
+func Run(t *testing.T, factory Factory) {
+	for _, tc := range []struct {
+		name string
+		test func(t *testing.T, factory Factory)
+	}{
+		{name: "random", test: testRandom},
+		{name: "no aliasing", test: testNoAliasing},
+		{name: "empty", test: testEmpty},
+		{name: "pagination", test: testPagination},
+		{name: "search", test: testSearch},
+		{name: "unicode case", test: testUnicodeCase},
+		{name: "mutations", test: testMutations},
+	} {
+		t.Run(tc.name, func(t *testing.T) { tc.test(t, factory) })
+	}
+}
+
+// testRandom checks that every matching phrase is eventually picked. The chance of false failure is ~5*(4/5)^500
+func testRandom(t *testing.T, factory Factory) {
+	ctx := context.Background()
+	repo := factory(t, Phrases)
+	pick := func(filter repository.Filter) map[string]int {
+		t.Helper()
+		seen := make(map[string]int)
+		for range 500 {
+			phrase, err := repo.Phrase(ctx, filter)
+			require.NoError(t, err)
+			require.Contains(t, Phrases, *phrase)
+			seen[phrase.ID]++
+		}
+		return seen
+	}
+
+	seen := pick(repository.Filter{})
+	assert.Len(t, seen, len(Phrases), "picked phrases: %v", seen)
+	seen = pick(repository.Filter{Tag: "MOTIVATION"})
+	assert.ElementsMatch(t, []string{"2", "4"}, slices.Collect(maps.Keys(seen)), "picked phrases: %v", seen)
+
+	_, err := repo.Phrase(ctx, repository.Filter{Tag: "unknown"})
+	require.ErrorIs(t, err, repository.ErrEmpty)
+}
+
+// testNoAliasing checks that modification of returned phrases doesn't affect the repository
+func testNoAliasing(t *testing.T, factory Factory) {
+	ctx := context.Background()
+	repo := factory(t, Phrases)
+	spoil := func(phrases ...*repository.Phrase) {
+		for _, phrase := range phrases {
+			phrase.Quote, phrase.Author = "spoiled", "spoiled"
+			if len(phrase.Tags) > 0 {
+				phrase.Tags[0] = "spoiled"
+			}
+			for lang := range phrase.Translations {
+				phrase.Translations[lang] = repository.Translation{Quote: "spoiled", Author: "spoiled"}
+			}
+		}
+	}
+
+	phrase, err := repo.Phrase(ctx, repository.Filter{Tag: "minimalism"})
+	require.NoError(t, err)
+	spoil(phrase)
+	phrase, err = repo.GetPhrase(ctx, "1")
+	require.NoError(t, err)
+	spoil(phrase)
+	phrases, _, err := repo.AllPhrases(ctx, repository.Page{})
+	require.NoError(t, err)
+	for i := range phrases {
+		spoil(&phrases[i])
+	}
+	phrases, err = repo.SearchPhrases(ctx, repository.Query{})
+	require.NoError(t, err)
+	for i := range phrases {
+		spoil(&phrases[i])
+	}
+
+	assert.ElementsMatch(t, Phrases, allPhrases(t, repo, 0))
+}
+
+func testEmpty(t *testing.T, factory Factory) {
+	ctx := context.Background()
+	repo := factory(t, nil)
+	_, err := repo.Phrase(ctx, repository.Filter{})
+	require.ErrorIs(t, err, repository.ErrEmpty)
+	_, err = repo.GetPhrase(ctx, "1")
+	require.ErrorIs(t, err, repository.ErrNotFound)
+
+	phrases, next, err := repo.AllPhrases(ctx, repository.Page{Size: 2})
+	require.NoError(t, err)
+	assert.Empty(t, phrases)
+	assert.Empty(t, next)
+
+	phrases, err = repo.SearchPhrases(ctx, repository.Query{})
+	require.NoError(t, err)
+	assert.Empty(t, phrases)
+}
+
+func testPagination(t *testing.T, factory Factory) {
+	ctx := context.Background()
+	repo := factory(t, Phrases)
+	all := allPhrases(t, repo, 0)
+	assert.ElementsMatch(t, Phrases, all)
+	// Order is stable regardless of page size
+	for size := 1; size <= len(Phrases)+1; size++ {
+		assert.Equal(t, all, allPhrases(t, repo, size), "page size %d", size)
+	}
+
+	_, _, err := repo.AllPhrases(ctx, repository.Page{Cursor: "!"})
+	require.ErrorIs(t, err, repository.ErrInvalidCursor)
+
+	// Changes between pages don't make the rest of phrases skipped or repeated
+	first, cursor, err := repo.AllPhrases(ctx, repository.Page{Size: 2})
+	require.NoError(t, err)
+	require.Len(t, first, 2)
+	require.NoError(t, repo.DeletePhrase(ctx, first[0].ID))
+	var rest []repository.Phrase
+	for cursor != "" {
+		var phrases []repository.Phrase
+		phrases, cursor, err = repo.AllPhrases(ctx, repository.Page{Cursor: cursor, Size: 2})
+		require.NoError(t, err)
+		rest = append(rest, phrases...)
+	}
+	assert.ElementsMatch(t, all[2:], rest)
+}
+
+func testSearch(t *testing.T, factory Factory) {
+	ctx := context.Background()
+	repo := factory(t, Phrases)
+	ids := func(query repository.Query) []string {
+		t.Helper()
+		phrases, err := repo.SearchPhrases(ctx, query)
+		require.NoError(t, err)
+		ids := make([]string, len(phrases))
+		for i, phrase := range phrases {
+			ids[i] = phrase.ID
+		}
+		return ids
+	}
+
+	assert.ElementsMatch(t, []string{"1", "2", "3", "4", "5"}, ids(repository.Query{}))
+	assert.ElementsMatch(t, []string{"1", "3"}, ids(repository.Query{Author: "MIES"}))
+	assert.ElementsMatch(t, []string{"1", "3"}, ids(repository.Query{Keyword: "LESS"}))
+	assert.ElementsMatch(t, []string{"1"}, ids(repository.Query{Author: "mies", Keyword: "less is"}))
+	assert.ElementsMatch(t, []string{"2"}, ids(repository.Query{Keyword: "0%"}))
+	assert.Empty(t, ids(repository.Query{Keyword: "_"}))
+	assert.Empty(t, ids(repository.Query{Author: "Mie"}))
+	assert.Empty(t, ids(repository.Query{Author: "Jobs", Keyword: "less"}))
+
+	limited := ids(repository.Query{Keyword: "s", Limit: 2})
+	assert.Len(t, limited, 2)
+	assert.Subset(t, []string{"1", "2", "3", "4", "5"}, limited)
+}
+
+// testUnicodeCase checks that case-insensitive matching isn't limited to ASCII
+func testUnicodeCase(t *testing.T, factory Factory) {
+	ctx := context.Background()
+	repo := factory(t, []repository.Phrase{{ID: "1", Quote: "Любовь всё прощает", Author: "Éluard", Tags: []string{"Юмор"}}})
+
+	phrase, err := repo.Phrase(ctx, repository.Filter{Tag: "юмор"})
+	require.NoError(t, err)
+	assert.Equal(t, "1", phrase.ID)
+	for _, query := range []repository.Query{
+		{Author: "éluard"},
+		{Keyword: "любовь"},
+		{Author: "ÉLUARD", Keyword: "ВСЁ ПРОЩАЕТ"},
+	} {
+		phrases, err := repo.SearchPhrases(ctx, query)
+		require.NoError(t, err)
+		assert.Len(t, phrases, 1, "query %+v", query)
+	}
+}
+
+func testMutations(t *testing.T, factory Factory) {
+	ctx := context.Background()
+	repo := factory(t, Phrases)
+
+	created, err := repo.CreatePhrase(ctx, repository.Phrase{ID: "ignored", Quote: "q", Author: "a", Tags: []string{"t1"}})
+	require.NoError(t, err)
+	assert.Equal(t, repository.Phrase{ID: repository.PhraseID("q", "a"), Quote: "q", Author: "a", Tags: []string{"t1"}},
+		*created)
+	phrase, err := repo.Phrase(ctx, repository.Filter{Tag: "T1"})
+	require.NoError(t, err)
+	assert.Equal(t, *created, *phrase)
+	_, err = repo.CreatePhrase(ctx, repository.Phrase{Quote: "q", Author: "a"})
+	require.ErrorIs(t, err, repository.ErrAlreadyExists)
+	_, err = repo.CreatePhrase(ctx, repository.Phrase{ID: "1", Quote: Phrases[1].Quote, Author: Phrases[1].Author})
+	require.ErrorIs(t, err, repository.ErrAlreadyExists)
+	_, err = repo.CreatePhrase(ctx, repository.Phrase{Quote: "q", Author: " "})
+	require.ErrorIs(t, err, repository.ErrInvalidPhrase)
+	_, err = repo.CreatePhrase(ctx, repository.Phrase{Quote: strings.Repeat("q", repository.MaxQuoteLen+1), Author: "a"})
+	require.ErrorIs(t, err, repository.ErrInvalidPhrase)
+	_, err = repo.CreatePhrase(ctx, repository.Phrase{Quote: "q3", Author: "a3", Tags: []string{"t", "T"}})
+	require.ErrorIs(t, err, repository.ErrInvalidPhrase)
+
+	updated, err := repo.UpdatePhrase(ctx, repository.Phrase{
+		ID:           created.ID,
+		Quote:        "q2",
+		Author:       "a2",
+		Tags:         []string{"t2", "t3"},
+		Language:     "en",
+		Translations: map[string]repository.Translation{"fr": {Quote: "q2-fr", Author: "a2-fr"}},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, created.ID, updated.ID)
+	_, err = repo.Phrase(ctx, repository.Filter{Tag: "t1"})
+	require.ErrorIs(t, err, repository.ErrEmpty)
+	phrase, err = repo.GetPhrase(ctx, created.ID)
+	require.NoError(t, err)
+	assert.Equal(t, *updated, *phrase)
+	_, err = repo.UpdatePhrase(ctx, repository.Phrase{ID: created.ID, Quote: "q2"})
+	require.ErrorIs(t, err, repository.ErrInvalidPhrase)
+	_, err = repo.UpdatePhrase(ctx, repository.Phrase{
+		ID:           created.ID,
+		Quote:        "q2",
+		Author:       "a2",
+		Language:     "en",
+		Translations: map[string]repository.Translation{"EN": {Quote: "q2", Author: "a2"}},
+	})
+	require.ErrorIs(t, err, repository.ErrInvalidPhrase)
+	_, err = repo.UpdatePhrase(ctx, repository.Phrase{ID: "unknown", Quote: "q", Author: "a"})
+	require.ErrorIs(t, err, repository.ErrNotFound)
+	_, err = repo.UpdatePhrase(ctx, repository.Phrase{ID: "unknown", Quote: Phrases[0].Quote, Author: Phrases[0].Author})
+	require.ErrorIs(t, err, repository.ErrNotFound)
+	_, err = repo.UpdatePhrase(ctx, repository.Phrase{ID: created.ID, Quote: Phrases[0].Quote, Author: Phrases[0].Author})
+	require.ErrorIs(t, err, repository.ErrAlreadyExists)
+
+	// ID derived from the previous content is kept by the updated phrase
+	recreated, err := repo.CreatePhrase(ctx, repository.Phrase{Quote: "q", Author: "a"})
+	require.NoError(t, err)
+	assert.NotEqual(t, created.ID, recreated.ID)
+	_, err = repo.UpdatePhrase(ctx, repository.Phrase{ID: recreated.ID, Quote: "q2", Author: "a2"})
+	require.ErrorIs(t, err, repository.ErrAlreadyExists)
+	require.NoError(t, repo.DeletePhrase(ctx, recreated.ID))
+
+	require.NoError(t, repo.DeletePhrase(ctx, created.ID))
+	_, err = repo.GetPhrase(ctx, created.ID)
+	require.ErrorIs(t, err, repository.ErrNotFound)
+	require.ErrorIs(t, repo.DeletePhrase(ctx, created.ID), repository.ErrNotFound)
+	assert.ElementsMatch(t, Phrases, allPhrases(t, repo, 0))
+}
+
+// allPhrases reads all pages of `size`
+func allPhrases(t *testing.T, repo repository.Repository, size int) []repository.Phrase {
+	ctx := context.Background()
+	t.Helper()
+	var all []repository.Phrase
+	cursor := ""
+	for {
+		phrases, next, err := repo.AllPhrases(ctx, repository.Page{Cursor: cursor, Size: size})
+		require.NoError(t, err)
+		if size > 0 {
+			require.LessOrEqual(t, len(phrases), size)
+		}
+		all = append(all, phrases...)
+		if next == "" {
+			break
+		}
+		require.NotEmpty(t, phrases, "non-last page is empty")
+		cursor = next
+	}
+	ids := make(map[string]struct{}, len(all))
+	for _, phrase := range all {
+		require.NotContains(t, ids, phrase.ID, "phrase is duplicated")
+		ids[phrase.ID] = struct{}{}
+	}
+	return all
+}
@@ -0,0 +1,76 @@
+package sql
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"io/fs"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/egsam98/errors"
+	"github.com/rs/zerolog/log"
+)
+
+// migrations are applied in order of file names `<version>_<name>.sql`. Applied versions are kept in
+// schema_migrations table. Every migration runs in its own transaction
+//
+//go:embed migrations/*.sql
+var migrations embed.FS
+
+func migrate(ctx context.Context, db *sql.DB) error {
+	if _, err := db.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS schema_migrations (version INTEGER PRIMARY KEY)`); err != nil {
+		return errors.Wrap(err, "create schema_migrations")
+	}
+	var current int
+	if err := db.QueryRowContext(ctx, `SELECT COALESCE(MAX(version), 0) FROM schema_migrations`).Scan(&current); err != nil {
+		return errors.Wrap(err, "select schema version")
+	}
+
+	// fs.Glob returns names in lexical order
+	names, err := fs.Glob(migrations, "migrations/*.sql")
+	if err != nil {
+		return errors.Wrap(err, "list migrations")
+	}
+	for _, name := range names {
+		prefix, _, _ := strings.Cut(path.Base(name), "_")
+		version, err := strconv.Atoi(prefix)
+		if err != nil {
+			return errors.Wrap(err, "parse version of migration %s", name)
+		}
+		if version <= current {
+			continue
+		}
+		stmts, err := migrations.ReadFile(name)
+		if err != nil {
+			return errors.Wrap(err, "read migration %s", name)
+		}
+		if err := apply(ctx, db, version, string(stmts)); err != nil {
+			return errors.Wrap(err, "apply migration %s", name)
+		}
+		log.Info().Str("migration", name).Msg("Migration is applied")
+	}
+	return nil
+}
+
+func apply(ctx context.Context, db *sql.DB, version int, stmts string) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return errors.Wrap(err, "begin transaction")
+	}
+	defer tx.Rollback() //nolint:errcheck
+	// Not every driver executes several statements at once
+	for _, stmt := range strings.Split(stmts, ";") {
+		if strings.TrimSpace(stmt) == "" {
+			continue
+		}
+		if _, err := tx.ExecContext(ctx, stmt); err != nil {
+			return errors.Wrap(err, "exec %s", stmt)
+		}
+	}
+	if _, err := tx.ExecContext(ctx, `INSERT INTO schema_migrations (version) VALUES ($1)`, version); err != nil {
+		return errors.Wrap(err, "insert schema version")
+	}
+	return errors.Wrap(tx.Commit(), "commit")
+}
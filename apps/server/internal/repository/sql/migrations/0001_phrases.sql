@@ -0,0 +1,10 @@
+-- Lower-cased copies are filled via strings.ToLower: LOWER() of SQLite folds ASCII only
+CREATE TABLE phrases (
+    id           TEXT PRIMARY KEY,
+    quote        TEXT NOT NULL,
+    author       TEXT NOT NULL,
+    quote_lower  TEXT NOT NULL,
+    author_lower TEXT NOT NULL
+);
+
+CREATE INDEX phrases_author_lower_idx ON phrases (author_lower);
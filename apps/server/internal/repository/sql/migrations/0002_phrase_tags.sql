@@ -0,0 +1,9 @@
+CREATE TABLE phrase_tags (
+    phrase_id TEXT    NOT NULL REFERENCES phrases (id),
+    position  INTEGER NOT NULL,
+    tag       TEXT    NOT NULL,
+    tag_lower TEXT    NOT NULL,
+    PRIMARY KEY (phrase_id, position)
+);
+
+CREATE INDEX phrase_tags_tag_lower_idx ON phrase_tags (tag_lower);
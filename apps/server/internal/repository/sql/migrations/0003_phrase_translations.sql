@@ -0,0 +1,9 @@
+ALTER TABLE phrases ADD COLUMN language TEXT NOT NULL DEFAULT '';
+
+CREATE TABLE phrase_translations (
+    phrase_id TEXT NOT NULL REFERENCES phrases (id),
+    language  TEXT NOT NULL,
+    quote     TEXT NOT NULL,
+    author    TEXT NOT NULL,
+    PRIMARY KEY (phrase_id, language)
+);
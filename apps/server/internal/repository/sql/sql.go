@@ -0,0 +1,410 @@
+package sql
+
+import (
+	"context"
+	"database/sql"
+	"encoding/base64"
+	"fmt"
+	"strings"
+
+	"github.com/egsam98/errors"
+
+	"github.com/egsam98/wow/apps/server/internal/repository"
+)
+
+// Repository keeps phrases in SQL database. Queries are compatible with both SQLite and PostgreSQL.
+// Texts are matched case-insensitively by their copies lower-cased via strings.ToLower: LOWER() of SQLite folds ASCII
+// only.
+// Phrases are ordered by ID, the cursor of page is the last ID of the previous page. Tags are kept in phrase_tags table
+// in order of their positions, translations are kept in phrase_translations table
+type Repository struct {
+	db *sql.DB
+}
+
+// NewRepository opens database of `driver` (e.g. "sqlite" or "pgx") and applies migrations.
+// Driver must be registered by caller
+func NewRepository(ctx context.Context, driver, dsn string) (*Repository, error) {
+	db, err := sql.Open(driver, dsn)
+	if err != nil {
+		return nil, errors.Wrap(err, "open %s database", driver)
+	}
+	if err := db.PingContext(ctx); err != nil {
+		_ = db.Close()
+		return nil, errors.Wrap(err, "ping %s database", driver)
+	}
+	if err := migrate(ctx, db); err != nil {
+		_ = db.Close()
+		return nil, err
+	}
+	return &Repository{db: db}, nil
+}
+
+func (r *Repository) Close() error {
+	return r.db.Close()
+}
+
+// Seed inserts phrases into database without phrases. Nothing is done otherwise, so that deleted phrases aren't
+// restored. Missing IDs are derived via repository.PhraseID.
+// Errors:
+// - repository.ErrInvalidPhrase
+func (r *Repository) Seed(ctx context.Context, phrases []repository.Phrase) error {
+	return r.tx(ctx, func(tx *sql.Tx) error {
+		var exists bool
+		if err := tx.QueryRowContext(ctx, `SELECT EXISTS (SELECT 1 FROM phrases)`).Scan(&exists); err != nil {
+			return errors.Wrap(err, "check existence of phrases")
+		}
+		if exists {
+			return nil
+		}
+		for _, phrase := range phrases {
+			if err := phrase.Validate(); err != nil {
+				return err
+			}
+			if phrase.ID == "" {
+				phrase.ID = repository.PhraseID(phrase.Quote, phrase.Author)
+			}
+			if _, err := insert(ctx, tx, phrase); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (r *Repository) Phrase(ctx context.Context, filter repository.Filter) (*repository.Phrase, error) {
+	q := query{sql: `SELECT id, quote, author, language FROM phrases`}
+	if filter.Tag != "" {
+		q.add(" WHERE id IN (SELECT phrase_id FROM phrase_tags WHERE tag_lower = %s)", strings.ToLower(filter.Tag))
+	}
+	q.add(" ORDER BY RANDOM() LIMIT 1")
+	phrases, err := r.query(ctx, q)
+	if err != nil {
+		return nil, err
+	}
+	if len(phrases) == 0 {
+		return nil, errors.Wrap(repository.ErrEmpty, "tag %q", filter.Tag)
+	}
+	return &phrases[0], nil
+}
+
+func (r *Repository) GetPhrase(ctx context.Context, id string) (*repository.Phrase, error) {
+	q := query{sql: `SELECT id, quote, author, language FROM phrases`}
+	q.add(" WHERE id = %s", id)
+	phrases, err := r.query(ctx, q)
+	if err != nil {
+		return nil, err
+	}
+	if len(phrases) == 0 {
+		return nil, errors.Wrap(repository.ErrNotFound, "ID %s", id)
+	}
+	return &phrases[0], nil
+}
+
+func (r *Repository) AllPhrases(ctx context.Context, page repository.Page) ([]repository.Phrase, string, error) {
+	after, err := base64.RawURLEncoding.DecodeString(page.Cursor)
+	if err != nil {
+		return nil, "", errors.Wrap(repository.ErrInvalidCursor, "%q", page.Cursor)
+	}
+	q := query{sql: `SELECT id, quote, author, language FROM phrases`}
+	q.add(" WHERE id > %s ORDER BY id", string(after))
+	// One more phrase is fetched to know whether the page is the last one
+	if page.Size > 0 {
+		q.add(" LIMIT %s", page.Size+1)
+	}
+	phrases, err := r.query(ctx, q)
+	if err != nil {
+		return nil, "", err
+	}
+	var next string
+	if page.Size > 0 && len(phrases) > page.Size {
+		phrases = phrases[:page.Size]
+		next = base64.RawURLEncoding.EncodeToString([]byte(phrases[len(phrases)-1].ID))
+	}
+	return phrases, next, nil
+}
+
+func (r *Repository) SearchPhrases(ctx context.Context, search repository.Query) ([]repository.Phrase, error) {
+	q := query{sql: `SELECT id, quote, author, language FROM phrases WHERE TRUE`}
+	if search.Author != "" {
+		q.add(" AND author_lower = %s", strings.ToLower(search.Author))
+	}
+	if search.Keyword != "" {
+		q.add(` AND quote_lower LIKE '%%' || %s || '%%' ESCAPE '\'`, escapeLike(strings.ToLower(search.Keyword)))
+	}
+	q.add(" ORDER BY id")
+	if search.Limit > 0 {
+		q.add(" LIMIT %s", search.Limit)
+	}
+	return r.query(ctx, q)
+}
+
+func (r *Repository) CreatePhrase(ctx context.Context, phrase repository.Phrase) (*repository.Phrase, error) {
+	if err := phrase.Validate(); err != nil {
+		return nil, err
+	}
+	err := r.tx(ctx, func(tx *sql.Tx) error {
+		if err := checkContent(ctx, tx, phrase); err != nil {
+			return err
+		}
+		for phrase.ID = range repository.PhraseIDs(phrase.Quote, phrase.Author) {
+			inserted, err := insert(ctx, tx, phrase)
+			if err != nil || inserted {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &phrase, nil
+}
+
+func (r *Repository) UpdatePhrase(ctx context.Context, phrase repository.Phrase) (*repository.Phrase, error) {
+	if err := phrase.Validate(); err != nil {
+		return nil, err
+	}
+	err := r.tx(ctx, func(tx *sql.Tx) error {
+		res, err := tx.ExecContext(ctx, `UPDATE phrases
+			SET quote = $1, author = $2, language = $3, quote_lower = $4, author_lower = $5 WHERE id = $6`,
+			phrase.Quote, phrase.Author, phrase.Language, strings.ToLower(phrase.Quote), strings.ToLower(phrase.Author),
+			phrase.ID)
+		if err := affected(res, err); err != nil {
+			return err
+		}
+		// Content is checked once the phrase is known to exist, the update is rolled back on collision
+		if err := checkContent(ctx, tx, phrase); err != nil {
+			return err
+		}
+		if err := deleteDetails(ctx, tx, phrase.ID); err != nil {
+			return err
+		}
+		return insertDetails(ctx, tx, phrase)
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "update phrase %s", phrase.ID)
+	}
+	return &phrase, nil
+}
+
+func (r *Repository) DeletePhrase(ctx context.Context, id string) error {
+	err := r.tx(ctx, func(tx *sql.Tx) error {
+		if err := deleteDetails(ctx, tx, id); err != nil {
+			return err
+		}
+		res, err := tx.ExecContext(ctx, `DELETE FROM phrases WHERE id = $1`, id)
+		return affected(res, err)
+	})
+	return errors.Wrap(err, "delete phrase %s", id)
+}
+
+// query phrases with their tags and translations
+func (r *Repository) query(ctx context.Context, q query) ([]repository.Phrase, error) {
+	var phrases []repository.Phrase
+	err := r.scan(ctx, q, func(rows *sql.Rows) error {
+		var phrase repository.Phrase
+		if err := rows.Scan(&phrase.ID, &phrase.Quote, &phrase.Author, &phrase.Language); err != nil {
+			return errors.Wrap(err, "scan phrase")
+		}
+		phrases = append(phrases, phrase)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	// Details are loaded in batches to fit limits of placeholders
+	const batch = 500
+	for from := 0; from < len(phrases); from += batch {
+		if err := r.loadDetails(ctx, phrases[from:min(from+batch, len(phrases))]); err != nil {
+			return nil, err
+		}
+	}
+	return phrases, nil
+}
+
+// loadDetails fills tags of phrases in order of their positions and translations
+func (r *Repository) loadDetails(ctx context.Context, phrases []repository.Phrase) error {
+	if len(phrases) == 0 {
+		return nil
+	}
+	byID := make(map[string]*repository.Phrase, len(phrases))
+	for i := range phrases {
+		byID[phrases[i].ID] = &phrases[i]
+	}
+
+	q := whereIn(`SELECT phrase_id, tag FROM phrase_tags`, phrases)
+	q.add(" ORDER BY phrase_id, position")
+	err := r.scan(ctx, q, func(rows *sql.Rows) error {
+		var id, tag string
+		if err := rows.Scan(&id, &tag); err != nil {
+			return errors.Wrap(err, "scan tag")
+		}
+		byID[id].Tags = append(byID[id].Tags, tag)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	q = whereIn(`SELECT phrase_id, language, quote, author FROM phrase_translations`, phrases)
+	return r.scan(ctx, q, func(rows *sql.Rows) error {
+		var id, lang string
+		var translation repository.Translation
+		if err := rows.Scan(&id, &lang, &translation.Quote, &translation.Author); err != nil {
+			return errors.Wrap(err, "scan translation")
+		}
+		phrase := byID[id]
+		if phrase.Translations == nil {
+			phrase.Translations = make(map[string]repository.Translation)
+		}
+		phrase.Translations[lang] = translation
+		return nil
+	})
+}
+
+// scan calls `fn` for every row of the query
+func (r *Repository) scan(ctx context.Context, q query, fn func(rows *sql.Rows) error) error {
+	rows, err := r.db.QueryContext(ctx, q.sql, q.args...)
+	if err != nil {
+		return errors.Wrap(err, "query %s", q.sql)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		if err := fn(rows); err != nil {
+			return err
+		}
+	}
+	return errors.Wrap(rows.Err(), "iterate rows of %s", q.sql)
+}
+
+// tx runs `fn` in transaction that's committed unless `fn` fails
+func (r *Repository) tx(ctx context.Context, fn func(tx *sql.Tx) error) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return errors.Wrap(err, "begin transaction")
+	}
+	defer tx.Rollback() //nolint:errcheck
+	if err := fn(tx); err != nil {
+		return err
+	}
+	return errors.Wrap(tx.Commit(), "commit")
+}
+
+// query is built of SQL parts with numbered placeholders
+type query struct {
+	sql  string
+	args []any
+}
+
+// whereIn builds `<selection> WHERE phrase_id IN (<IDs of phrases>)`
+func whereIn(selection string, phrases []repository.Phrase) query {
+	q := query{sql: selection + " WHERE phrase_id IN ("}
+	for i, phrase := range phrases {
+		if i > 0 {
+			q.add(", ")
+		}
+		q.add("%s", phrase.ID)
+	}
+	q.add(")")
+	return q
+}
+
+// add appends `part` replacing %s with placeholder of `arg`
+func (q *query) add(part string, arg ...any) {
+	if len(arg) == 0 {
+		q.sql += part
+		return
+	}
+	q.args = append(q.args, arg[0])
+	q.sql += fmt.Sprintf(part, fmt.Sprintf("$%d", len(q.args)))
+}
+
+// insert phrase with its details. Returns false if phrase with the same ID exists
+func insert(ctx context.Context, tx *sql.Tx, phrase repository.Phrase) (bool, error) {
+	res, err := tx.ExecContext(ctx, `INSERT INTO phrases (id, quote, author, language, quote_lower, author_lower)
+		VALUES ($1, $2, $3, $4, $5, $6) ON CONFLICT (id) DO NOTHING`,
+		phrase.ID, phrase.Quote, phrase.Author, phrase.Language, strings.ToLower(phrase.Quote),
+		strings.ToLower(phrase.Author))
+	if err != nil {
+		return false, errors.Wrap(err, "insert phrase %s", phrase.ID)
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return false, errors.Wrap(err, "rows affected")
+	}
+	if n == 0 {
+		return false, nil
+	}
+	return true, insertDetails(ctx, tx, phrase)
+}
+
+// checkContent checks that quote and author of phrase differ from ones of other phrases.
+// Errors:
+// - repository.ErrAlreadyExists
+func checkContent(ctx context.Context, tx *sql.Tx, phrase repository.Phrase) error {
+	var id string
+	err := tx.QueryRowContext(ctx, `SELECT id FROM phrases WHERE quote = $1 AND author = $2 AND id <> $3 LIMIT 1`,
+		phrase.Quote, phrase.Author, phrase.ID).Scan(&id)
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		return nil
+	case err != nil:
+		return errors.Wrap(err, "select phrase with the same content")
+	default:
+		return errors.Wrap(repository.ErrAlreadyExists, "phrase %s", id)
+	}
+}
+
+// insertDetails inserts tags and translations of phrase
+func insertDetails(ctx context.Context, tx *sql.Tx, phrase repository.Phrase) error {
+	for i, tag := range phrase.Tags {
+		if _, err := tx.ExecContext(ctx,
+			`INSERT INTO phrase_tags (phrase_id, position, tag, tag_lower) VALUES ($1, $2, $3, $4)`,
+			phrase.ID, i, tag, strings.ToLower(tag)); err != nil {
+			return errors.Wrap(err, "insert tag %s of phrase %s", tag, phrase.ID)
+		}
+	}
+	for lang, translation := range phrase.Translations {
+		if _, err := tx.ExecContext(ctx,
+			`INSERT INTO phrase_translations (phrase_id, language, quote, author) VALUES ($1, $2, $3, $4)`,
+			phrase.ID, lang, translation.Quote, translation.Author); err != nil {
+			return errors.Wrap(err, "insert translation %s of phrase %s", lang, phrase.ID)
+		}
+	}
+	return nil
+}
+
+// deleteDetails deletes tags and translations of phrase
+func deleteDetails(ctx context.Context, tx *sql.Tx, id string) error {
+	for _, stmt := range []string{
+		`DELETE FROM phrase_tags WHERE phrase_id = $1`,
+		`DELETE FROM phrase_translations WHERE phrase_id = $1`,
+	} {
+		if _, err := tx.ExecContext(ctx, stmt, id); err != nil {
+			return errors.Wrap(err, "exec %s", stmt)
+		}
+	}
+	return nil
+}
+
+// affected checks that statement changed a row.
+// Errors:
+// - repository.ErrNotFound if no rows are affected
+func affected(res sql.Result, err error) error {
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return errors.Wrap(err, "rows affected")
+	}
+	if n == 0 {
+		return repository.ErrNotFound
+	}
+	return nil
+}
+
+// escapeLike escapes wildcards of LIKE pattern
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
@@ -0,0 +1,117 @@
+package sql
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	_ "modernc.org/sqlite"
+
+	"github.com/egsam98/wow/apps/server/internal/repository"
+	"github.com/egsam98/wow/apps/server/internal/repository/repositorytest"
+)
+
+func TestRepository_Contract(t *testing.T) {
+	ctx := context.Background()
+	repositorytest.Run(t, func(t *testing.T, phrases []repository.Phrase) repository.Repository {
+		repo, err := NewRepository(ctx, "sqlite", "file:"+filepath.Join(t.TempDir(), "wow.db"))
+		require.NoError(t, err)
+		t.Cleanup(func() { _ = repo.Close() })
+		require.NoError(t, repo.Seed(ctx, phrases))
+		return repo
+	})
+}
+
+func TestRepository(t *testing.T) {
+	ctx := context.Background()
+	dsn := "file:" + filepath.Join(t.TempDir(), "wow.db")
+	repo, err := NewRepository(ctx, "sqlite", dsn)
+	require.NoError(t, err)
+	require.NoError(t, repo.Close())
+	// Migrations are applied once
+	repo, err = NewRepository(ctx, "sqlite", dsn)
+	require.NoError(t, err)
+	defer repo.Close()
+
+	_, err = repo.Phrase(ctx, repository.Filter{})
+	require.ErrorIs(t, err, repository.ErrEmpty)
+
+	require.NoError(t, repo.Seed(ctx, []repository.Phrase{
+		{ID: "1", Quote: "Less is more", Author: "Mies"},
+		{ID: "2", Quote: "100% of the shots", Author: "Gretzky"},
+		{ID: "3", Quote: "More or less", Author: "mies"},
+	}))
+
+	t.Run("pagination", func(t *testing.T) {
+		var ids []string
+		var pages int
+		for cursor := ""; pages == 0 || cursor != ""; pages++ {
+			phrases, next, err := repo.AllPhrases(ctx, repository.Page{Cursor: cursor, Size: 2})
+			require.NoError(t, err)
+			for _, phrase := range phrases {
+				ids = append(ids, phrase.ID)
+			}
+			cursor = next
+		}
+		assert.Equal(t, 2, pages)
+		assert.Equal(t, []string{"1", "2", "3"}, ids)
+
+		_, _, err := repo.AllPhrases(ctx, repository.Page{Cursor: "!"})
+		require.ErrorIs(t, err, repository.ErrInvalidCursor)
+	})
+
+	t.Run("search", func(t *testing.T) {
+		phrases, err := repo.SearchPhrases(ctx, repository.Query{Author: "MIES", Keyword: "LESS"})
+		require.NoError(t, err)
+		assert.Len(t, phrases, 2)
+
+		phrases, err = repo.SearchPhrases(ctx, repository.Query{Keyword: "0%", Limit: 1})
+		require.NoError(t, err)
+		require.Len(t, phrases, 1)
+		assert.Equal(t, "2", phrases[0].ID)
+
+		phrases, err = repo.SearchPhrases(ctx, repository.Query{Keyword: "_"})
+		require.NoError(t, err)
+		assert.Empty(t, phrases)
+	})
+
+	t.Run("mutations", func(t *testing.T) {
+		created, err := repo.CreatePhrase(ctx, repository.Phrase{Quote: "q", Author: "a"})
+		require.NoError(t, err)
+		assert.Equal(t, repository.PhraseID("q", "a"), created.ID)
+		_, err = repo.CreatePhrase(ctx, repository.Phrase{Quote: "q", Author: "a"})
+		require.ErrorIs(t, err, repository.ErrAlreadyExists)
+		_, err = repo.CreatePhrase(ctx, repository.Phrase{Quote: "q"})
+		require.ErrorIs(t, err, repository.ErrInvalidPhrase)
+
+		_, err = repo.UpdatePhrase(ctx, repository.Phrase{ID: created.ID, Quote: "q2", Author: "a2"})
+		require.NoError(t, err)
+		phrase, err := repo.GetPhrase(ctx, created.ID)
+		require.NoError(t, err)
+		assert.Equal(t, repository.Phrase{ID: created.ID, Quote: "q2", Author: "a2"}, *phrase)
+
+		require.NoError(t, repo.DeletePhrase(ctx, created.ID))
+		_, err = repo.GetPhrase(ctx, created.ID)
+		require.ErrorIs(t, err, repository.ErrNotFound)
+		require.ErrorIs(t, repo.DeletePhrase(ctx, created.ID), repository.ErrNotFound)
+	})
+
+	t.Run("canceled context", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(ctx)
+		cancel()
+		_, err := repo.GetPhrase(ctx, "1")
+		require.ErrorIs(t, err, context.Canceled)
+		_, err = repo.CreatePhrase(ctx, repository.Phrase{Quote: "q", Author: "a"})
+		require.ErrorIs(t, err, context.Canceled)
+	})
+
+	t.Run("seed", func(t *testing.T) {
+		require.NoError(t, repo.DeletePhrase(ctx, "3"))
+		// Deleted phrase isn't restored
+		require.NoError(t, repo.Seed(ctx, []repository.Phrase{{ID: "3", Quote: "More or less", Author: "mies"}}))
+		_, err := repo.GetPhrase(ctx, "3")
+		require.ErrorIs(t, err, repository.ErrNotFound)
+	})
+}
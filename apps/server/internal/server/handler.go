@@ -4,11 +4,13 @@ import (
 	"context"
 	"iter"
 
+	"github.com/egsam98/errors"
+
 	"github.com/egsam98/wow/apps/server/internal/repository"
 	"github.com/egsam98/wow/internal/api"
 )
 
-// Handler impls api.ServerHandler handling TCP requests
+// Handler impls api.ServerHandler and api.AdminHandler handling TCP requests
 type Handler struct {
 	repo repository.Repository
 }
@@ -17,25 +19,151 @@ func NewHandler(repo repository.Repository) *Handler {
 	return &Handler{repo: repo}
 }
 
-func (h *Handler) Phrase(context.Context, *api.PhraseRequest) (*api.PhraseResponse, error) {
-	phrase, err := h.repo.Phrase()
+func (h *Handler) Phrase(ctx context.Context, req *api.PhraseRequest) (*api.PhraseResponse, error) {
+	phrase, err := h.repo.Phrase(ctx, repository.Filter{Tag: req.Tag})
+	if err != nil {
+		return nil, public(err)
+	}
+	return response(phrase.Localize(req.Languages)), nil
+}
+
+// Ready reports whether there are phrases to serve. The first page of single phrase is cheaper than random pick.
+// Errors:
+// - repository.ErrEmpty. It's public as api.ErrUnavailable
+func (h *Handler) Ready(ctx context.Context) error {
+	phrases, _, err := h.repo.AllPhrases(ctx, repository.Page{Size: 1})
+	if err != nil {
+		return err
+	}
+	if len(phrases) == 0 {
+		return api.Public(repository.ErrEmpty, api.ErrUnavailable.WithDetails("no phrases"))
+	}
+	return nil
+}
+
+func (h *Handler) GetPhrase(ctx context.Context, req *api.GetPhraseRequest) (*api.PhraseResponse, error) {
+	phrase, err := h.repo.GetPhrase(ctx, req.ID)
 	if err != nil {
-		return nil, err
+		return nil, public(err)
 	}
-	return (*api.PhraseResponse)(phrase), nil
+	return response(*phrase), nil
 }
 
-func (h *Handler) AllPhrases(context.Context, *api.AllPhrasesRequest) iter.Seq2[*api.PhraseResponse, error] {
+func (h *Handler) AllPhrases(ctx context.Context, req *api.AllPhrasesRequest) api.Page[*api.PhraseResponse] {
+	var next string
+	return api.Page[*api.PhraseResponse]{
+		Items: streamPhrases(func() ([]repository.Phrase, error) {
+			phrases, cursor, err := h.repo.AllPhrases(ctx, repository.Page{Cursor: req.Cursor, Size: int(req.PageSize)})
+			if err != nil {
+				return nil, public(err)
+			}
+			next = cursor
+			for i := range phrases {
+				phrases[i] = phrases[i].Localize(req.Languages)
+			}
+			return phrases, nil
+		}),
+		Next: func() string { return next },
+	}
+}
+
+func (h *Handler) SearchPhrases(ctx context.Context, req *api.SearchPhrasesRequest) iter.Seq2[*api.PhraseResponse, error] {
+	return streamPhrases(func() ([]repository.Phrase, error) {
+		return h.repo.SearchPhrases(ctx, repository.Query{
+			Author:  req.Author,
+			Keyword: req.Keyword,
+			Limit:   int(req.Limit),
+		})
+	})
+}
+
+func (h *Handler) CreatePhrase(ctx context.Context, req *api.CreatePhraseRequest) (*api.PhraseResponse, error) {
+	phrase, err := h.repo.CreatePhrase(ctx, repository.Phrase{
+		Quote:        req.Quote,
+		Author:       req.Author,
+		Tags:         req.Tags,
+		Language:     req.Language,
+		Translations: translations(req.Translations),
+	})
+	if err != nil {
+		return nil, public(err)
+	}
+	return response(*phrase), nil
+}
+
+func (h *Handler) UpdatePhrase(ctx context.Context, req *api.UpdatePhraseRequest) (*api.PhraseResponse, error) {
+	phrase, err := h.repo.UpdatePhrase(ctx, repository.Phrase{
+		ID:           req.ID,
+		Quote:        req.Quote,
+		Author:       req.Author,
+		Tags:         req.Tags,
+		Language:     req.Language,
+		Translations: translations(req.Translations),
+	})
+	if err != nil {
+		return nil, public(err)
+	}
+	return response(*phrase), nil
+}
+
+func (h *Handler) DeletePhrase(ctx context.Context, req *api.DeletePhraseRequest) error {
+	return public(h.repo.DeletePhrase(ctx, req.ID))
+}
+
+// public exposes errors of repository contract to client
+func public(err error) error {
+	switch {
+	case errors.Is(err, repository.ErrNotFound):
+		return api.Public(err, api.ErrNotFound)
+	case errors.Is(err, repository.ErrEmpty):
+		return api.Public(err, api.ErrNotFound.WithDetails("no phrases"))
+	case errors.Is(err, repository.ErrInvalidCursor):
+		return api.Public(err, api.ErrBadRequest.WithDetails("invalid cursor"))
+	case errors.Is(err, repository.ErrInvalidPhrase):
+		return api.Public(err, api.ErrBadRequest.WithDetails(err.Error()))
+	case errors.Is(err, repository.ErrAlreadyExists):
+		return api.Public(err, api.ErrConflict)
+	case errors.Is(err, repository.ErrReadOnly):
+		return api.Public(err, api.ErrForbidden.WithDetails("phrases are read-only"))
+	default:
+		return err
+	}
+}
+
+// streamPhrases yields phrases loaded by `load` once iteration is started
+func streamPhrases(load func() ([]repository.Phrase, error)) iter.Seq2[*api.PhraseResponse, error] {
 	return func(yield func(*api.PhraseResponse, error) bool) {
-		phrases, err := h.repo.AllPhrases()
+		phrases, err := load()
 		if err != nil {
 			yield(nil, err)
 			return
 		}
 		for _, phrase := range phrases {
-			if !yield((*api.PhraseResponse)(&phrase), nil) {
+			if !yield(response(phrase), nil) {
 				return
 			}
 		}
 	}
 }
+
+// response converts phrase into api.PhraseResponse omitting translations
+func response(phrase repository.Phrase) *api.PhraseResponse {
+	return &api.PhraseResponse{
+		ID:       phrase.ID,
+		Quote:    phrase.Quote,
+		Author:   phrase.Author,
+		Tags:     phrase.Tags,
+		Language: phrase.Language,
+	}
+}
+
+func translations(from map[string]api.Translation) map[string]repository.Translation {
+	if from == nil {
+		return nil
+	}
+	to := make(map[string]repository.Translation, len(from))
+	for lang, translation := range from {
+		to[lang] = repository.Translation(translation)
+	}
+	return to
+}
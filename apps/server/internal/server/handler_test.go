@@ -0,0 +1,100 @@
+package server
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/egsam98/wow/apps/server/internal/repository"
+	"github.com/egsam98/wow/apps/server/internal/repository/mem"
+	"github.com/egsam98/wow/apps/server/internal/repository/repositorytest"
+	"github.com/egsam98/wow/internal/api"
+)
+
+func TestHandler(t *testing.T) {
+	var repo mem.Repository
+	require.NoError(t, repo.Replace(repositorytest.Phrases))
+	h := NewHandler(&repo)
+	ctx := context.Background()
+	require.NoError(t, h.Ready(ctx))
+
+	t.Run("get phrase", func(t *testing.T) {
+		res, err := h.GetPhrase(ctx, &api.GetPhraseRequest{ID: "1"})
+		require.NoError(t, err)
+		assert.Equal(t, api.PhraseResponse{
+			ID:       "1",
+			Quote:    "Less is more",
+			Author:   "Mies",
+			Tags:     []string{"minimalism"},
+			Language: "en",
+		}, *res)
+
+		_, err = h.GetPhrase(ctx, &api.GetPhraseRequest{ID: "unknown"})
+		require.ErrorIs(t, err, api.ErrNotFound)
+		require.ErrorIs(t, err, repository.ErrNotFound)
+	})
+
+	t.Run("localized phrase", func(t *testing.T) {
+		var repo mem.Repository
+		require.NoError(t, repo.Replace(repositorytest.Phrases[:1]))
+		res, err := NewHandler(&repo).Phrase(ctx, &api.PhraseRequest{Languages: []string{"fr", "de-CH"}})
+		require.NoError(t, err)
+		assert.Equal(t, "de", res.Language)
+		assert.Equal(t, "Weniger ist mehr", res.Quote)
+
+		page := h.AllPhrases(ctx, &api.AllPhrasesRequest{PageSize: 1, Languages: []string{"ru"}})
+		for res, err := range page.Items {
+			require.NoError(t, err)
+			assert.Equal(t, "ru", res.Language)
+			assert.Equal(t, "Меньше значит больше", res.Quote)
+		}
+	})
+
+	t.Run("all phrases", func(t *testing.T) {
+		page := h.AllPhrases(ctx, &api.AllPhrasesRequest{PageSize: 3})
+		var ids []string
+		for res, err := range page.Items {
+			require.NoError(t, err)
+			ids = append(ids, res.ID)
+		}
+		assert.Equal(t, []string{"1", "2", "3"}, ids)
+		assert.NotEmpty(t, page.Next())
+
+		page = h.AllPhrases(ctx, &api.AllPhrasesRequest{Cursor: "!"})
+		for _, err := range page.Items {
+			require.ErrorIs(t, err, api.ErrBadRequest)
+		}
+	})
+
+	t.Run("search phrases", func(t *testing.T) {
+		var ids []string
+		for res, err := range h.SearchPhrases(ctx, &api.SearchPhrasesRequest{Author: "mies", Limit: 1}) {
+			require.NoError(t, err)
+			ids = append(ids, res.ID)
+		}
+		assert.Equal(t, []string{"1"}, ids)
+	})
+
+	t.Run("empty", func(t *testing.T) {
+		var repo mem.Repository
+		require.NoError(t, repo.Replace(nil))
+		h := NewHandler(&repo)
+		require.ErrorIs(t, h.Ready(ctx), repository.ErrEmpty)
+		require.ErrorIs(t, h.Ready(ctx), api.ErrUnavailable)
+		_, err := h.Phrase(ctx, new(api.PhraseRequest))
+		require.ErrorIs(t, err, api.ErrNotFound)
+	})
+
+	t.Run("mutations", func(t *testing.T) {
+		created, err := h.CreatePhrase(ctx, &api.CreatePhraseRequest{Quote: "q", Author: "a"})
+		require.NoError(t, err)
+		_, err = h.CreatePhrase(ctx, &api.CreatePhraseRequest{Quote: "q", Author: "a"})
+		require.ErrorIs(t, err, api.ErrConflict)
+		_, err = h.UpdatePhrase(ctx, &api.UpdatePhraseRequest{ID: created.ID, Quote: "q"})
+		require.ErrorIs(t, err, api.ErrBadRequest)
+		require.NoError(t, h.DeletePhrase(ctx, &api.DeletePhraseRequest{ID: created.ID}))
+		require.ErrorIs(t, h.DeletePhrase(ctx, &api.DeletePhraseRequest{ID: created.ID}), api.ErrNotFound)
+	})
+}
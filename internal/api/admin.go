@@ -0,0 +1,90 @@
+package api
+
+import (
+	"context"
+	"crypto/subtle"
+	"crypto/tls"
+	"fmt"
+	"slices"
+)
+
+// AdminHandler handles write requests of clients authorized as admin.
+// Returned errors follow the same rules as ServerHandler's ones
+type AdminHandler interface {
+	CreatePhrase(context.Context, *CreatePhraseRequest) (*PhraseResponse, error)
+	UpdatePhrase(context.Context, *UpdatePhraseRequest) (*PhraseResponse, error)
+	DeletePhrase(context.Context, *DeletePhraseRequest) error
+}
+
+// Admin authorizes write operations. Admin clients don't solve PoW
+type Admin struct {
+	Handler AdminHandler
+	// Tokens are accepted from authRequest, see WithAdminToken
+	Tokens []string
+	// Identities are common names of verified TLS client certificates (see Listener.TLS)
+	Identities []string
+}
+
+// WithAdmin enables write operations for clients authorized as admin.
+// Otherwise, write requests are rejected with ErrForbidden
+func WithAdmin(admin Admin) ServerOption {
+	return func(s *Server) {
+		s.admin = admin
+	}
+}
+
+// auth authorizes connection by admin token.
+// Errors:
+// - ErrForbidden if token isn't accepted. The connection is closed
+func (s *Server) auth(conn *serverConn, req *authRequest) error {
+	for _, token := range s.admin.Tokens {
+		if subtle.ConstantTimeCompare([]byte(token), []byte(req.Token)) == 1 {
+			return conn.write(&authResponse{MaxRequestLen: s.grantAdmin(conn)})
+		}
+	}
+	return ErrForbidden.WithDetails("invalid admin token")
+}
+
+// grantAdmin marks connection as admin one raising limit of its requests. Returns the limit
+func (s *Server) grantAdmin(conn *serverConn) uint32 {
+	conn.admin = true
+	conn.codec.readLimit = max(conn.codec.readLimit, s.limits.AdminRequest)
+	return conn.codec.readLimit
+}
+
+// isAdmin reports whether connection is authorized by admin token or TLS client certificate
+func (s *Server) isAdmin(conn *serverConn) bool {
+	if conn.admin {
+		return true
+	}
+	tlsConn, ok := conn.Conn.(*tls.Conn)
+	if !ok {
+		return false
+	}
+	for _, chain := range tlsConn.ConnectionState().VerifiedChains {
+		if slices.Contains(s.admin.Identities, chain[0].Subject.CommonName) {
+			return true
+		}
+	}
+	return false
+}
+
+// manage handles write request of admin. Other clients receive ErrForbidden
+func (s *Server) manage(ctx context.Context, conn *serverConn, msg message) error {
+	if s.admin.Handler == nil || !s.isAdmin(conn) {
+		return conn.write(ErrForbidden.WithDetails("admin authorization is required"))
+	}
+	switch msg := msg.(type) {
+	case *CreatePhraseRequest:
+		res, err := s.admin.Handler.CreatePhrase(ctx, msg)
+		return respond(conn, res, err)
+	case *UpdatePhraseRequest:
+		res, err := s.admin.Handler.UpdatePhrase(ctx, msg)
+		return respond(conn, res, err)
+	case *DeletePhraseRequest:
+		err := s.admin.Handler.DeletePhrase(ctx, msg)
+		return respond(conn, new(deletePhraseResponse), err)
+	default:
+		return conn.write(ErrBadRequest.WithDetails(fmt.Sprintf("unexpected message %v (%T)", msg, msg)))
+	}
+}
@@ -2,47 +2,376 @@ package api
 
 import (
 	"context"
+	"crypto/tls"
 	"encoding/binary"
 	"iter"
 	"math"
 	"net"
+	"sync"
 	"time"
 
+	"github.com/coder/websocket"
 	"github.com/egsam98/errors"
+	"github.com/rs/zerolog/log"
 
 	"github.com/egsam98/wow/internal/pow"
 )
 
-// Client connects to Words of Wisdom server
+const dialTimeout = 5 * time.Second
+
+// ErrStreamInProgress is returned on request made while stream of the same client is iterated
+var ErrStreamInProgress = errors.New("connection is busy with stream")
+
+// Client connects to Words of Wisdom server.
+// Client is safe for concurrent use, however requests are sent one by one over single connection.
+// Streams occupy the connection until iteration is over: requests made meanwhile, e.g. from the loop body, fail with
+// ErrStreamInProgress. Keepalive pings are suspended during streams
 type Client struct {
-	conn net.Conn
+	conn  net.Conn
+	codec codec
+	// mu guards conn during request/response exchange
+	mu sync.Mutex
+	// err is set once connection is considered dead
+	err error
+	// streaming is true while stream is iterated, see ErrStreamInProgress
+	streaming bool
+	// window is a flow control window of streams, see StreamRequest.Window
+	window uint
+	// batchSize is a number of stream items per frame, see StreamRequest.BatchSize
+	batchSize uint
+	// compression lists algorithms offered at handshake
+	compression []string
+	// adminToken authorizes write operations, see WithAdminToken
+	adminToken string
+	// languages are preferred languages of phrases, see WithLanguages
+	languages         []string
+	keepaliveInterval time.Duration
+	keepaliveTimeout  time.Duration
+	done              chan struct{}
+	closeOnce         sync.Once
+}
+
+type ClientOption func(*Client)
+
+// WithKeepalive pings server every `interval` while connection isn't busy with requests.
+// If pong isn't received in `timeout` the connection is closed and subsequent requests fail immediately
+func WithKeepalive(interval, timeout time.Duration) ClientOption {
+	return func(c *Client) {
+		c.keepaliveInterval = interval
+		c.keepaliveTimeout = timeout
+	}
+}
+
+// WithStreamWindow enables flow control of streams: server writes at most `window` frames ahead of consumer.
+// Credits are granted back as soon as half of the window is consumed
+func WithStreamWindow(window uint) ClientOption {
+	return func(c *Client) {
+		c.window = window
+	}
+}
+
+// WithStreamBatch requests server to pack up to `size` stream items into single frame
+func WithStreamBatch(size uint) ClientOption {
+	return func(c *Client) {
+		c.batchSize = size
+	}
+}
+
+// WithMaxMessageLen limits frames client is ready to read. The limit is negotiated with server at handshake.
+// Default is DefaultResponseLen
+func WithMaxMessageLen(n uint32) ClientOption {
+	return func(c *Client) {
+		c.codec.readLimit = n
+	}
 }
 
-func Dial(addr string) (*Client, error) {
-	conn, err := net.DialTimeout("tcp", addr, 5*time.Second)
+// WithCompression offers server to compress frames with CompressionFlate
+func WithCompression() ClientOption {
+	return func(c *Client) {
+		c.compression = []string{CompressionFlate}
+	}
+}
+
+// WithAdminToken authorizes connection as admin right after handshake, so that write operations are allowed.
+// Alternatively, admin is authorized by TLS client certificate passed to DialTLS
+func WithAdminToken(token string) ClientOption {
+	return func(c *Client) {
+		c.adminToken = token
+	}
+}
+
+// WithLanguages requests phrases in preferred languages (BCP 47 tags, e.g. "pt-BR") unless they're set by request,
+// see PhraseRequest.Languages
+func WithLanguages(languages ...string) ClientOption {
+	return func(c *Client) {
+		c.languages = languages
+	}
+}
+
+// Dial connects to server and performs handshake. Address prefixed with `unix://` is a path of Unix domain socket.
+// Errors:
+// - ErrVersionMismatch if server doesn't support client's protocol version
+// - ErrForbidden if admin token isn't accepted
+func Dial(addr string, opts ...ClientOption) (*Client, error) {
+	network, addr := splitAddr(addr)
+	conn, err := net.DialTimeout(network, addr, dialTimeout)
 	if err != nil {
 		return nil, errors.Wrap(err, "connect to WordsOfWisdom server")
 	}
-	return &Client{conn: conn}, nil
+	return newClient(conn, opts...)
 }
 
-func (c *Client) Phrase(ctx context.Context) (*PhraseResponse, error) {
-	return clientSync[*PhraseRequest, *PhraseResponse](c, ctx, new(PhraseRequest))
+// DialTLS connects to TLS listener of server (see Listener.TLS) and performs handshake.
+// Errors:
+// - ErrVersionMismatch if server doesn't support client's protocol version
+func DialTLS(addr string, config *tls.Config, opts ...ClientOption) (*Client, error) {
+	network, addr := splitAddr(addr)
+	conn, err := tls.DialWithDialer(&net.Dialer{Timeout: dialTimeout}, network, addr, config)
+	if err != nil {
+		return nil, errors.Wrap(err, "connect to WordsOfWisdom server")
+	}
+	return newClient(conn, opts...)
+}
+
+// DialWebSocket connects to server via WebSocket (see Server.ServeWebSocket) and performs handshake.
+// Errors:
+// - ErrVersionMismatch if server doesn't support client's protocol version
+func DialWebSocket(ctx context.Context, url string, opts ...ClientOption) (*Client, error) {
+	ctx, cancel := context.WithTimeout(ctx, dialTimeout)
+	defer cancel()
+	ws, _, err := websocket.Dial(ctx, url, nil) //nolint:bodyclose
+	if err != nil {
+		return nil, errors.Wrap(err, "connect to WordsOfWisdom server")
+	}
+	// Connection outlives dialing context
+	return newClient(websocket.NetConn(context.Background(), ws, websocket.MessageBinary), opts...)
+}
+
+func newClient(conn net.Conn, opts ...ClientOption) (*Client, error) {
+	c := &Client{
+		conn: conn,
+		codec: codec{
+			readLimit:  DefaultResponseLen,
+			writeLimit: DefaultRequestLen,
+		},
+		done: make(chan struct{}),
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	if err := c.hello(); err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+	if c.adminToken != "" {
+		res, err := clientSync[*authRequest, *authResponse](c, context.Background(), &authRequest{Token: c.adminToken})
+		if err != nil {
+			_ = conn.Close()
+			return nil, err
+		}
+		if res.MaxRequestLen > 0 {
+			c.codec.writeLimit = res.MaxRequestLen
+		}
+	}
+	if c.keepaliveInterval > 0 {
+		go c.keepalive()
+	}
+	return c, nil
+}
+
+// Phrase returns random phrase matching filters of `req`.
+// Errors:
+// - ErrNotFound if there are no such phrases
+func (c *Client) Phrase(ctx context.Context, req PhraseRequest) (*PhraseResponse, error) {
+	if req.Languages == nil {
+		req.Languages = c.languages
+	}
+	return clientSync[*PhraseRequest, *PhraseResponse](c, ctx, &req)
+}
+
+// GetPhrase returns phrase by its ID.
+// Errors:
+// - ErrNotFound
+func (c *Client) GetPhrase(ctx context.Context, id string) (*PhraseResponse, error) {
+	return clientSync[*GetPhraseRequest, *PhraseResponse](c, ctx, &GetPhraseRequest{ID: id})
+}
+
+// CreatePhrase adds phrase. Admin authorization is required.
+// Errors:
+// - ErrForbidden
+// - ErrBadRequest if phrase isn't valid
+// - ErrConflict if the same phrase exists
+func (c *Client) CreatePhrase(ctx context.Context, req CreatePhraseRequest) (*PhraseResponse, error) {
+	return clientSync[*CreatePhraseRequest, *PhraseResponse](c, ctx, &req)
+}
+
+// UpdatePhrase replaces content of the phrase keeping its ID. Admin authorization is required.
+// Errors:
+// - ErrForbidden
+// - ErrBadRequest if phrase isn't valid
+// - ErrNotFound
+// - ErrConflict if another phrase has the same quote and author
+func (c *Client) UpdatePhrase(ctx context.Context, req UpdatePhraseRequest) (*PhraseResponse, error) {
+	return clientSync[*UpdatePhraseRequest, *PhraseResponse](c, ctx, &req)
+}
+
+// DeletePhrase deletes phrase by its ID. Admin authorization is required.
+// Errors:
+// - ErrForbidden
+// - ErrNotFound
+func (c *Client) DeletePhrase(ctx context.Context, id string) error {
+	_, err := clientSync[*DeletePhraseRequest, *deletePhraseResponse](c, ctx, &DeletePhraseRequest{ID: id})
+	return err
 }
 
 func (c *Client) AllPhrases(ctx context.Context) iter.Seq2[*PhraseResponse, error] {
-	return clientStream[*AllPhrasesRequest, *PhraseResponse](c, ctx, new(AllPhrasesRequest))
+	req := &AllPhrasesRequest{Languages: c.languages}
+	return clientStream[*AllPhrasesRequest, *PhraseResponse](c, ctx, req, nil)
+}
+
+// PhrasesPage fetches up to `size` phrases starting from `cursor`, empty cursor means the first page.
+// Returns cursor of the next page, it's empty if the page is the last one
+func (c *Client) PhrasesPage(ctx context.Context, cursor string, size uint) ([]PhraseResponse, string, error) {
+	req := &AllPhrasesRequest{PageSize: size, Cursor: cursor, Languages: c.languages}
+	var next string
+	it := clientStream[*AllPhrasesRequest, *PhraseResponse](c, ctx, req, func(tail *streamTombstoneResponse) {
+		next = tail.NextCursor
+	})
+	var phrases []PhraseResponse
+	for res, err := range it {
+		if err != nil {
+			return nil, "", err
+		}
+		phrases = append(phrases, *res)
+	}
+	return phrases, next, nil
+}
+
+// SearchPhrases streams phrases matching filters of `req`
+func (c *Client) SearchPhrases(ctx context.Context, req SearchPhrasesRequest) iter.Seq2[*PhraseResponse, error] {
+	return clientStream[*SearchPhrasesRequest, *PhraseResponse](c, ctx, &req, nil)
+}
+
+// Ping checks connection liveness. PoW isn't required for ping
+func (c *Client) Ping(ctx context.Context) error {
+	_, err := clientSync[*pingRequest, *pongResponse](c, ctx, new(pingRequest))
+	return err
+}
+
+func (c *Client) Close() error {
+	var err error
+	c.closeOnce.Do(func() {
+		close(c.done)
+		err = c.conn.Close()
+	})
+	return err
+}
+
+// hello performs handshake negotiating connection settings
+func (c *Client) hello() error {
+	_ = c.conn.SetDeadline(deadline(dialTimeout))
+	defer c.conn.SetDeadline(time.Time{}) //nolint:errcheck
+	req := &helloRequest{
+		Version:       protocolVersion,
+		MaxMessageLen: c.codec.readLimit,
+		Compression:   c.compression,
+	}
+	if err := c.codec.write(c.conn, req); err != nil {
+		return errors.Wrap(err, "helloRequest: write request")
+	}
+	msg, err := c.codec.read(c.conn)
+	if err != nil {
+		return errors.Wrap(err, "helloRequest: read response")
+	}
+	switch msg := msg.(type) {
+	case *helloResponse:
+		c.codec.writeLimit = msg.MaxRequestLen
+		c.codec.compression = msg.Compression
+		c.codec.compressionThreshold = msg.CompressionThreshold
+		return nil
+	case *ErrorResponse:
+		return msg
+	default:
+		return errors.Errorf("unexpected response message %#v", msg)
+	}
+}
+
+// keepalive pings server until the client is closed
+func (c *Client) keepalive() {
+	ticker := time.NewTicker(c.keepaliveInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-c.done:
+			return
+		case <-ticker.C:
+		}
+
+		// Connection is busy, i.e. alive
+		if !c.mu.TryLock() {
+			continue
+		}
+		if c.streaming {
+			c.mu.Unlock()
+			continue
+		}
+		err := c.ping(c.keepaliveTimeout)
+		if err != nil {
+			c.err = errors.Wrap(err, "keepalive")
+		}
+		c.mu.Unlock()
+		if err != nil {
+			log.Err(err).Str("addr", c.conn.RemoteAddr().String()).Msg("Keepalive: close dead connection")
+			_ = c.Close()
+			return
+		}
+	}
 }
 
-func (c *Client) Close() error { return c.conn.Close() }
+// busy returns error if connection can't serve request. Must be called under lock.
+// Errors:
+// - ErrStreamInProgress
+// - error of dead connection
+func (c *Client) busy() error {
+	if c.err != nil {
+		return c.err
+	}
+	if c.streaming {
+		return ErrStreamInProgress
+	}
+	return nil
+}
+
+// ping must be called under lock
+func (c *Client) ping(timeout time.Duration) error {
+	_ = c.conn.SetReadDeadline(deadline(timeout))
+	defer c.conn.SetReadDeadline(time.Time{}) //nolint:errcheck
+	if err := c.codec.write(c.conn, new(pingRequest)); err != nil {
+		return err
+	}
+	msg, err := c.codec.read(c.conn)
+	if err != nil {
+		return err
+	}
+	if _, ok := msg.(*pongResponse); !ok {
+		return errors.Errorf("unexpected response message %#v", msg)
+	}
+	return nil
+}
 
 func clientSync[In, Out message](c *Client, ctx context.Context, req In) (Out, error) {
 	var zero Out
-	if err := write(c.conn, req); err != nil {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err := c.busy(); err != nil {
+		return zero, err
+	}
+
+	if err := c.codec.write(c.conn, req); err != nil {
 		return zero, errors.Wrap(err, "%T: write request", req)
 	}
 	for {
-		msg, err := read(c.conn)
+		msg, err := c.codec.read(c.conn)
 		if err != nil {
 			return zero, errors.Wrap(err, "%T: read response", req)
 		}
@@ -52,7 +381,7 @@ func clientSync[In, Out message](c *Client, ctx context.Context, req In) (Out, e
 			if err != nil {
 				return zero, err
 			}
-			if err := write(c.conn, &powNonceRequest{Nonce: nonce}); err != nil {
+			if err := c.codec.write(c.conn, &powNonceRequest{Nonce: nonce}); err != nil {
 				return zero, errors.Wrap(err, "powNonceRequest: write request")
 			}
 		case Out:
@@ -65,44 +394,117 @@ func clientSync[In, Out message](c *Client, ctx context.Context, req In) (Out, e
 	}
 }
 
-func clientStream[In, Out message](c *Client, ctx context.Context, req In) iter.Seq2[Out, error] {
-	var zero Out
-	if err := write(c.conn, req); err != nil {
-		return func(yield func(Out, error) bool) { yield(zero, errors.Wrap(err, "%T: write request", req)) }
-	}
-
+// clientStream requests stream of `Out` items. Optional `tail` receives stream's tombstone.
+// The lock is released while items are yielded, so that requests from the loop body fail with ErrStreamInProgress
+// instead of deadlock
+func clientStream[In streamMessage, Out message](
+	c *Client,
+	ctx context.Context,
+	req In,
+	tail func(*streamTombstoneResponse),
+) iter.Seq2[Out, error] {
 	return func(yield func(Out, error) bool) {
-		for {
-			msg, err := read(c.conn)
-			if err != nil {
-				yield(zero, errors.Wrap(err, "%T: read response", req))
-				return
+		var zero Out
+		c.mu.Lock()
+		if err := c.busy(); err != nil {
+			c.mu.Unlock()
+			yield(zero, err)
+			return
+		}
+		c.streaming = true
+
+		req.stream().Window = c.window
+		req.stream().BatchSize = c.batchSize
+		var consumed uint
+		// consume yields frame's items granting credits back to server. Returns false if stream is over
+		consume := func(items ...Out) (bool, error) {
+			for _, item := range items {
+				c.mu.Unlock()
+				ok := yield(item, nil)
+				c.mu.Lock()
+				if !ok {
+					if err := c.cancelStream(); err != nil {
+						c.err = errors.Wrap(err, "%T: cancel stream", req)
+					}
+					return false, nil
+				}
 			}
-			switch msg := msg.(type) {
-			case *powChallengeResponse:
-				nonce, err := computePoW(ctx, msg.Challenge, msg.Zeros)
+			if consumed++; c.window > 0 && consumed >= max(c.window/2, 1) {
+				if err := c.codec.write(c.conn, &streamCreditRequest{Credits: consumed}); err != nil {
+					c.err = errors.Wrap(err, "streamCreditRequest: write request")
+					return false, c.err
+				}
+				consumed = 0
+			}
+			return true, nil
+		}
+		// receive reads frames until the stream is over. Returned error is yielded once the lock is released
+		receive := func() error {
+			if err := c.codec.write(c.conn, req); err != nil {
+				return errors.Wrap(err, "%T: write request", req)
+			}
+			for {
+				msg, err := c.codec.read(c.conn)
 				if err != nil {
-					yield(zero, err)
-					return
+					return errors.Wrap(err, "%T: read response", req)
 				}
-				if err := write(c.conn, &powNonceRequest{Nonce: nonce}); err != nil {
-					yield(zero, errors.Wrap(err, "PowNonceRequest: write request"))
-					return
+				var items []Out
+				switch msg := msg.(type) {
+				case *powChallengeResponse:
+					nonce, err := computePoW(ctx, msg.Challenge, msg.Zeros)
+					if err != nil {
+						return err
+					}
+					if err := c.codec.write(c.conn, &powNonceRequest{Nonce: nonce}); err != nil {
+						return errors.Wrap(err, "PowNonceRequest: write request")
+					}
+					continue
+				case Out:
+					items = []Out{msg}
+				case *streamBatchResponse:
+					if items, err = unbatch[Out](msg); err != nil {
+						return err
+					}
+				case *streamTombstoneResponse:
+					if tail != nil {
+						tail(msg)
+					}
+					return nil
+				case *ErrorResponse:
+					return msg
+				default:
+					return errors.Errorf("unexpected response message %#v", msg)
 				}
-			case Out:
-				if !yield(msg, nil) {
-					return
+				if ok, err := consume(items...); !ok {
+					return err
 				}
-			case *streamTombstoneResponse:
-				return
-			case *ErrorResponse:
-				yield(zero, msg)
-				return
-			default:
-				yield(zero, errors.Errorf("unexpected response message %#v", msg))
-				return
 			}
 		}
+
+		err := receive()
+		c.streaming = false
+		c.mu.Unlock()
+		if err != nil {
+			yield(zero, err)
+		}
+	}
+}
+
+// cancelStream stops streaming on server side and drains the rest of the stream so that connection is reusable.
+// Must be called under lock
+func (c *Client) cancelStream() error {
+	if err := c.codec.write(c.conn, new(cancelStreamRequest)); err != nil {
+		return err
+	}
+	for {
+		msg, err := c.codec.read(c.conn)
+		if err != nil {
+			return err
+		}
+		switch msg.(type) {
+		case *streamTombstoneResponse, *ErrorResponse:
+			return nil
+		}
 	}
 }
 
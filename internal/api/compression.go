@@ -0,0 +1,54 @@
+package api
+
+import (
+	"bytes"
+	"compress/flate"
+	"io"
+	"sync"
+
+	"github.com/egsam98/errors"
+)
+
+// CompressionFlate is the only supported compression algorithm of frames, see WithCompression
+const CompressionFlate = "flate"
+
+// flagCompressed is set in frame header if frame's body is compressed
+const flagCompressed uint32 = 1 << 31
+
+var flateWriters = sync.Pool{
+	New: func() any {
+		w, _ := flate.NewWriter(nil, flate.DefaultCompression)
+		return w
+	},
+}
+
+func compress(body []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	w := flateWriters.Get().(*flate.Writer)
+	defer flateWriters.Put(w)
+	w.Reset(&buf)
+	if _, err := w.Write(body); err != nil {
+		return nil, errors.Wrap(err, "compress")
+	}
+	if err := w.Close(); err != nil {
+		return nil, errors.Wrap(err, "compress")
+	}
+	return buf.Bytes(), nil
+}
+
+// decompress body protecting from decompression bombs by `limit`.
+// Errors:
+// - ErrMessageTooLarge if decompressed body exceeds limit
+// - ErrBadRequest if body isn't compressed properly
+func decompress(body []byte, limit uint32) ([]byte, error) {
+	r := flate.NewReader(bytes.NewReader(body))
+	defer r.Close()
+	res, err := io.ReadAll(io.LimitReader(r, int64(limit)+1))
+	if err != nil {
+		return nil, Public(errors.Wrap(err, "decompress"), ErrBadRequest.WithDetails("invalid compressed frame"))
+	}
+	if uint32(len(res)) > limit {
+		return nil, ErrMessageTooLarge.WithDetails("decompressed frame exceeds limit")
+	}
+	return res, nil
+}
@@ -0,0 +1,139 @@
+package api
+
+import (
+	"io/fs"
+	"net"
+	"os"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/egsam98/errors"
+)
+
+const unixScheme = "unix://"
+
+// splitAddr splits address into network and network-specific address.
+// Addresses prefixed with `unix://` are Unix domain sockets, the rest are TCP ones
+func splitAddr(addr string) (network, address string) {
+	if path, ok := strings.CutPrefix(addr, unixScheme); ok {
+		return "unix", path
+	}
+	return "tcp", addr
+}
+
+// removeStaleSocket removes Unix domain socket file left by previous process
+func removeStaleSocket(path string) error {
+	info, err := os.Stat(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return errors.Wrap(err, "stat socket %s", path)
+	}
+	if info.Mode()&fs.ModeSocket == 0 {
+		return errors.Errorf("%s exists and isn't a socket", path)
+	}
+	return errors.Wrap(os.Remove(path), "remove stale socket %s", path)
+}
+
+// isLocal reports whether connection is established via Unix domain socket
+func isLocal(conn net.Conn) bool {
+	_, ok := conn.RemoteAddr().(*net.UnixAddr)
+	return ok
+}
+
+// Timeouts of TCP connection stages. Zero value disables corresponding timeout
+type Timeouts struct {
+	// Read limits reading of a request once its first byte is received
+	Read time.Duration
+	// PoW limits solving of puzzle by client including reading of the nonce
+	PoW time.Duration
+	// Write limits writing of every response frame
+	Write time.Duration
+	// Idle limits waiting for the next request on keep-alive connection
+	Idle time.Duration
+	// Keepalive limits period without granted requests (passed PoW or authorized as admin), so that connections
+	// kept by pings only don't hold slots of Listener.MaxConns. The period starts on connecting
+	Keepalive time.Duration
+}
+
+// serverConn is a server side of connection applying Timeouts and codec settings
+type serverConn struct {
+	net.Conn
+	codec    codec
+	timeouts Timeouts
+	// onRead is a read timeout applied after first received byte
+	onRead atomic.Int64
+	// pending is a result of reading that was started by detached streamControl
+	pending chan readResult
+	// admin is true once client is authorized by admin token
+	admin bool
+}
+
+type readResult struct {
+	msg message
+	err error
+}
+
+func newServerConn(conn net.Conn, timeouts Timeouts, limits MessageLimits) *serverConn {
+	return &serverConn{
+		Conn: conn,
+		codec: codec{
+			readLimit: limits.Request,
+			// Until handshake the client is considered legacy one that reads small frames only
+			writeLimit: limits.Request,
+		},
+		timeouts: timeouts,
+	}
+}
+
+func (c *serverConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	if n > 0 {
+		if onRead := c.onRead.Swap(0); onRead > 0 {
+			_ = c.Conn.SetReadDeadline(deadline(time.Duration(onRead)))
+		}
+	}
+	return n, err
+}
+
+func (c *serverConn) Write(b []byte) (int, error) {
+	_ = c.Conn.SetWriteDeadline(deadline(c.timeouts.Write))
+	return c.Conn.Write(b)
+}
+
+// awaitRequest limits waiting for the request by `wait` timeout.
+// Once the request starts arriving the rest of it is limited by Timeouts.Read
+func (c *serverConn) awaitRequest(wait time.Duration) {
+	c.onRead.Store(int64(c.timeouts.Read))
+	_ = c.Conn.SetReadDeadline(deadline(wait))
+}
+
+// awaitPoW limits reading by Timeouts.PoW
+func (c *serverConn) awaitPoW() {
+	c.onRead.Store(0)
+	_ = c.Conn.SetReadDeadline(deadline(c.timeouts.PoW))
+}
+
+// readRequest reads the next request or takes over the one that has been being read by detached streamControl
+func (c *serverConn) readRequest() (message, error) {
+	if c.pending != nil {
+		res := <-c.pending
+		c.pending = nil
+		return res.msg, res.err
+	}
+	return c.read()
+}
+
+func (c *serverConn) read() (message, error) { return c.codec.read(c) }
+
+func (c *serverConn) write(msg message) error { return c.codec.write(c, msg) }
+
+// deadline returns zero time (no deadline) for zero timeout
+func deadline(timeout time.Duration) time.Time {
+	if timeout <= 0 {
+		return time.Time{}
+	}
+	return time.Now().Add(timeout)
+}
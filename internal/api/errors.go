@@ -0,0 +1,139 @@
+package api
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"net"
+	"time"
+
+	"github.com/egsam98/errors"
+	"github.com/rs/zerolog/log"
+)
+
+// ErrorCode is a stable code of ErrorResponse that clients may rely on instead of message text
+type ErrorCode string
+
+const (
+	CodeBadRequest      ErrorCode = "bad_request"
+	CodePoWFailed       ErrorCode = "pow_failed"
+	CodePoWExpired      ErrorCode = "pow_expired"
+	CodeRateLimited     ErrorCode = "rate_limited"
+	CodeNotFound        ErrorCode = "not_found"
+	CodeInternal        ErrorCode = "internal"
+	CodeUnavailable     ErrorCode = "unavailable"
+	CodeVersionMismatch ErrorCode = "version_mismatch"
+	CodeMessageTooLarge ErrorCode = "message_too_large"
+	CodeForbidden       ErrorCode = "forbidden"
+	CodeConflict        ErrorCode = "conflict"
+)
+
+// Sentinel errors to compare ErrorResponse with via errors.Is. Only ErrorCode is taken into account
+var (
+	ErrBadRequest      = &ErrorResponse{Code: CodeBadRequest, Message: "bad request"}
+	ErrPoWFailed       = &ErrorResponse{Code: CodePoWFailed, Message: "proof of work failed"}
+	ErrPoWExpired      = &ErrorResponse{Code: CodePoWExpired, Message: "proof of work expired"}
+	ErrRateLimited     = &ErrorResponse{Code: CodeRateLimited, Message: "rate limited"}
+	ErrNotFound        = &ErrorResponse{Code: CodeNotFound, Message: "not found"}
+	ErrInternal        = &ErrorResponse{Code: CodeInternal, Message: "internal error"}
+	ErrUnavailable     = &ErrorResponse{Code: CodeUnavailable, Message: "service unavailable"}
+	ErrVersionMismatch = &ErrorResponse{Code: CodeVersionMismatch, Message: "protocol version mismatch"}
+	ErrMessageTooLarge = &ErrorResponse{Code: CodeMessageTooLarge, Message: "message is too large"}
+	ErrForbidden       = &ErrorResponse{Code: CodeForbidden, Message: "forbidden"}
+	ErrConflict        = &ErrorResponse{Code: CodeConflict, Message: "conflict"}
+)
+
+type ErrorResponse struct {
+	Code    ErrorCode `json:"code"`
+	Message string    `json:"message"`
+	// RetryAfter is an optional hint for client when to repeat the request
+	RetryAfter time.Duration `json:"retry_after,omitempty"`
+	// Details is an optional human-readable explanation
+	Details string `json:"details,omitempty"`
+	// CorrelationID identifies internal error in server logs
+	CorrelationID string `json:"correlation_id,omitempty"`
+}
+
+func (e *ErrorResponse) Error() string {
+	msg := string(e.Code) + ": " + e.Message
+	if e.Details != "" {
+		msg += ": " + e.Details
+	}
+	if e.CorrelationID != "" {
+		msg += " (correlation ID: " + e.CorrelationID + ")"
+	}
+	return msg
+}
+
+// Public impls PublicError
+func (e *ErrorResponse) Public() *ErrorResponse { return e }
+
+// Is reports whether target is ErrorResponse with the same code
+func (e *ErrorResponse) Is(target error) bool {
+	t, ok := target.(*ErrorResponse) //nolint:errorlint
+	return ok && t.Code == e.Code
+}
+
+// WithDetails copies ErrorResponse setting details
+func (e *ErrorResponse) WithDetails(details string) *ErrorResponse {
+	copied := *e
+	copied.Details = details
+	return &copied
+}
+
+// WithRetryAfter copies ErrorResponse setting retry-after hint
+func (e *ErrorResponse) WithRetryAfter(d time.Duration) *ErrorResponse {
+	copied := *e
+	copied.RetryAfter = d
+	return &copied
+}
+
+func (*ErrorResponse) opCode() opCode { return errorResp }
+
+// PublicError is implemented by errors that opt in to be sent to client as is.
+// Any other error returned by ServerHandler is hidden behind ErrInternal
+type PublicError interface {
+	error
+	Public() *ErrorResponse
+}
+
+// Public annotates internal error with client-safe response.
+// The result matches both err and res via errors.Is
+func Public(err error, res *ErrorResponse) error {
+	if err == nil {
+		return nil
+	}
+	return &publicError{cause: err, res: res}
+}
+
+type publicError struct {
+	cause error
+	res   *ErrorResponse
+}
+
+func (e *publicError) Error() string          { return e.cause.Error() }
+func (e *publicError) Unwrap() []error        { return []error{e.cause, e.res} }
+func (e *publicError) Public() *ErrorResponse { return e.res }
+
+// toErrorResponse converts error into ErrorResponse that's safe to be sent to client.
+// Errors not implementing PublicError are logged with generated correlation ID and replaced with ErrInternal
+func toErrorResponse(from net.IP, err error) *ErrorResponse {
+	var pubErr PublicError
+	if errors.As(err, &pubErr) {
+		return pubErr.Public()
+	}
+	res := *ErrInternal
+	res.CorrelationID = newCorrelationID()
+	log.Error().
+		Stack().
+		Err(err).
+		Str("correlation_id", res.CorrelationID).
+		IPAddr("from", from).
+		Msg("Handle")
+	return &res
+}
+
+func newCorrelationID() string {
+	var buf [8]byte
+	_, _ = rand.Read(buf[:])
+	return hex.EncodeToString(buf[:])
+}
@@ -0,0 +1,370 @@
+package api
+
+import (
+	"cmp"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/egsam98/errors"
+	"github.com/rs/zerolog/log"
+
+	"github.com/egsam98/wow/internal/pow"
+)
+
+// Gateway serves ServerHandler over HTTP/JSON for clients that can't speak TCP protocol (browsers, curl):
+// - GET /phrase?tag=<tag> responds with PhraseResponse. Tag is optional
+// - GET /phrases responds with PhraseResponse stream in NDJSON format. Failed stream ends with ErrorResponse line
+//
+// Phrases are localized according to Accept-Language header.
+//
+// Proof of work is requested via HTTP challenge/response:
+//  1. Request without Authorization header is responded with 401 Unauthorized and
+//     `WWW-Authenticate: PoW challenge="<hex>", zeros="<N>", token="<token>"`
+//  2. Client selects 8-byte nonce such that SHA-256(challenge + nonce) starts with N zero bytes (see pow.Verify)
+//     and repeats the request with `Authorization: PoW token="<token>", nonce="<hex>"`
+//
+// The token is signed by Gateway, expires in challengeTTL and is accepted only once
+type Gateway struct {
+	addr    string
+	handler ServerHandler
+	puzzle  *pow.Puzzle
+	ttl     time.Duration
+	secret  [32]byte
+	mux     *http.ServeMux
+	// reqs is a number of in-flight requests that determines puzzle complexity
+	reqs atomic.Int32
+
+	mu sync.Mutex
+	// used contains challenges that have been already accepted until their expiration
+	used      map[[pow.ChalLen]byte]time.Time
+	lastSweep time.Time
+}
+
+func NewGateway(addr string, handler ServerHandler, puzzle *pow.Puzzle, challengeTTL time.Duration) (*Gateway, error) {
+	g := &Gateway{
+		addr:    addr,
+		handler: handler,
+		puzzle:  puzzle,
+		ttl:     challengeTTL,
+		mux:     http.NewServeMux(),
+		used:    make(map[[pow.ChalLen]byte]time.Time),
+	}
+	if _, err := rand.Read(g.secret[:]); err != nil {
+		return nil, errors.Wrap(err, "generate challenge secret")
+	}
+	g.mux.HandleFunc("GET /phrase", g.phrase)
+	g.mux.HandleFunc("GET /phrases", g.allPhrases)
+	return g, nil
+}
+
+// Listen serves HTTP requests. The method blocks until the context is canceled
+func (g *Gateway) Listen(ctx context.Context) error {
+	return errors.Wrap(listenHTTP(ctx, g.addr, g), "listen gateway")
+}
+
+func (g *Gateway) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	g.reqs.Add(1)
+	defer g.reqs.Add(-1)
+	g.mux.ServeHTTP(w, r)
+}
+
+func (g *Gateway) phrase(w http.ResponseWriter, r *http.Request) {
+	if !g.verifyPoW(w, r) {
+		return
+	}
+	res, err := g.handler.Phrase(r.Context(), &PhraseRequest{
+		Tag:       r.URL.Query().Get("tag"),
+		Languages: acceptLanguages(r.Header.Get("Accept-Language")),
+	})
+	if err != nil {
+		writeHTTPError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, res)
+}
+
+func (g *Gateway) allPhrases(w http.ResponseWriter, r *http.Request) {
+	if !g.verifyPoW(w, r) {
+		return
+	}
+
+	enc := json.NewEncoder(w)
+	flusher, _ := w.(http.Flusher)
+	var started bool
+	req := &AllPhrasesRequest{Languages: acceptLanguages(r.Header.Get("Accept-Language"))}
+	for res, err := range g.handler.AllPhrases(r.Context(), req).Items {
+		if err != nil {
+			if !started {
+				writeHTTPError(w, r, err)
+				return
+			}
+			// Status is already sent
+			_ = enc.Encode(toErrorResponse(remoteIP(r), err))
+			return
+		}
+		if !started {
+			w.Header().Set("Content-Type", "application/x-ndjson")
+			started = true
+		}
+		if err := enc.Encode(res); err != nil {
+			log.Debug().Err(err).IPAddr("to", remoteIP(r)).Msg("Write phrases")
+			return
+		}
+		if flusher != nil {
+			flusher.Flush()
+		}
+	}
+	if !started {
+		w.Header().Set("Content-Type", "application/x-ndjson")
+	}
+}
+
+// verifyPoW checks solution of the challenge from Authorization header.
+// Otherwise, the new challenge is responded and false is returned
+func (g *Gateway) verifyPoW(w http.ResponseWriter, r *http.Request) bool {
+	header := r.Header.Get("Authorization")
+	if header == "" {
+		g.challenge(w, r, ErrPoWFailed.WithDetails("proof of work is required"))
+		return false
+	}
+
+	err := g.verify(header)
+	if err == nil {
+		return true
+	}
+	var errRes *ErrorResponse
+	if errors.As(err, &errRes) && (errRes.Code == CodePoWFailed || errRes.Code == CodePoWExpired) {
+		g.challenge(w, r, errRes)
+		return false
+	}
+	writeHTTPError(w, r, err)
+	return false
+}
+
+// verify Authorization header.
+// Errors:
+// - ErrBadRequest if header is malformed
+// - ErrPoWFailed if token is forged or used or nonce isn't verified
+// - ErrPoWExpired if token is expired
+func (g *Gateway) verify(header string) error {
+	params, ok := parseAuthParams(header)
+	if !ok {
+		return ErrBadRequest.WithDetails(`Authorization header must be in format: PoW token="<token>", nonce="<hex>"`)
+	}
+	token, err := base64.RawURLEncoding.DecodeString(params["token"])
+	if err != nil || len(token) != tokenPayloadLen+sha256.Size {
+		return ErrBadRequest.WithDetails("malformed token")
+	}
+	var nonce [8]byte
+	if n, err := hex.Decode(nonce[:], []byte(params["nonce"])); err != nil || n != len(nonce) {
+		return ErrBadRequest.WithDetails("nonce must be 8 bytes in hex")
+	}
+
+	payload, mac := token[:tokenPayloadLen], token[tokenPayloadLen:]
+	if !hmac.Equal(mac, g.mac(payload)) {
+		return ErrPoWFailed.WithDetails("forged token")
+	}
+	var challenge [pow.ChalLen]byte
+	copy(challenge[:], payload)
+	zeros := uint(payload[pow.ChalLen])
+	expiry := time.Unix(int64(binary.BigEndian.Uint64(payload[pow.ChalLen+1:])), 0)
+	if time.Now().After(expiry) {
+		return ErrPoWExpired
+	}
+
+	if err := pow.Verify(challenge, zeros, nonce); err != nil {
+		if errors.Is(err, pow.ErrVerify) {
+			return ErrPoWFailed
+		}
+		return err
+	}
+	if !g.use(challenge, expiry) {
+		return ErrPoWFailed.WithDetails("token is already used")
+	}
+	return nil
+}
+
+// challenge responds with 401 Unauthorized issuing new signed challenge
+func (g *Gateway) challenge(w http.ResponseWriter, r *http.Request, reason *ErrorResponse) {
+	challenge, zeros, err := g.puzzle.Challenge(uint(g.reqs.Load()))
+	if err != nil {
+		writeHTTPError(w, r, err)
+		return
+	}
+
+	// Token payload: challenge | zeros (1 byte) | expiry (unix seconds, 8 bytes)
+	payload := make([]byte, 0, tokenPayloadLen+sha256.Size)
+	payload = append(payload, challenge[:]...)
+	payload = append(payload, byte(zeros))
+	payload = binary.BigEndian.AppendUint64(payload, uint64(time.Now().Add(g.ttl).Unix()))
+	token := base64.RawURLEncoding.EncodeToString(append(payload, g.mac(payload)...))
+
+	w.Header().Set("WWW-Authenticate", fmt.Sprintf(`PoW challenge="%x", zeros="%d", token="%s"`, challenge, zeros, token))
+	writeJSON(w, http.StatusUnauthorized, reason)
+}
+
+const tokenPayloadLen = pow.ChalLen + 1 + 8
+
+func (g *Gateway) mac(payload []byte) []byte {
+	h := hmac.New(sha256.New, g.secret[:])
+	h.Write(payload)
+	return h.Sum(nil)
+}
+
+// use marks challenge as used until its expiration. Returns false if challenge has been already used
+func (g *Gateway) use(challenge [pow.ChalLen]byte, expiry time.Time) bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	now := time.Now()
+	if now.Sub(g.lastSweep) > g.ttl {
+		for c, exp := range g.used {
+			if now.After(exp) {
+				delete(g.used, c)
+			}
+		}
+		g.lastSweep = now
+	}
+
+	if _, ok := g.used[challenge]; ok {
+		return false
+	}
+	g.used[challenge] = expiry
+	return true
+}
+
+// listenHTTP serves HTTP requests until the context is canceled
+func listenHTTP(ctx context.Context, addr string, handler http.Handler) error {
+	srv := &http.Server{
+		Addr:              addr,
+		Handler:           handler,
+		ReadHeaderTimeout: 5 * time.Second,
+		BaseContext:       func(net.Listener) context.Context { return ctx },
+	}
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := srv.Shutdown(shutdownCtx); err != nil {
+			log.Err(err).Str("addr", addr).Msg("Shutdown HTTP server")
+		}
+	}()
+
+	if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
+
+// parseAuthParams parses `PoW key1="value1", key2="value2"` header
+func parseAuthParams(header string) (map[string]string, bool) {
+	scheme, rest, ok := strings.Cut(header, " ")
+	if !ok || !strings.EqualFold(scheme, "PoW") {
+		return nil, false
+	}
+	params := make(map[string]string)
+	for _, param := range strings.Split(rest, ",") {
+		key, value, ok := strings.Cut(strings.TrimSpace(param), "=")
+		if !ok {
+			return nil, false
+		}
+		params[strings.ToLower(key)] = strings.Trim(value, `"`)
+	}
+	return params, true
+}
+
+// acceptLanguages parses `Accept-Language: da, en-GB;q=0.8, en;q=0.7` header into languages in order of preference.
+// Wildcard and languages with zero or malformed weight are skipped
+func acceptLanguages(header string) []string {
+	type weighted struct {
+		lang   string
+		weight float64
+	}
+	var langs []weighted
+	for _, item := range strings.Split(header, ",") {
+		lang, params, _ := strings.Cut(strings.TrimSpace(item), ";")
+		if lang == "" || lang == "*" {
+			continue
+		}
+		weight := 1.0
+		if q, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			var err error
+			if weight, err = strconv.ParseFloat(q, 64); err != nil {
+				continue
+			}
+		}
+		if weight > 0 {
+			langs = append(langs, weighted{lang: lang, weight: weight})
+		}
+	}
+	slices.SortStableFunc(langs, func(a, b weighted) int { return cmp.Compare(b.weight, a.weight) })
+
+	res := make([]string, len(langs))
+	for i, lang := range langs {
+		res[i] = lang.lang
+	}
+	return res
+}
+
+func writeHTTPError(w http.ResponseWriter, r *http.Request, err error) {
+	res := toErrorResponse(remoteIP(r), err)
+	if res.RetryAfter > 0 {
+		w.Header().Set("Retry-After", strconv.Itoa(int(res.RetryAfter.Seconds())))
+	}
+	writeJSON(w, httpStatus(res.Code), res)
+}
+
+func httpStatus(code ErrorCode) int {
+	switch code {
+	case CodeBadRequest, CodeVersionMismatch:
+		return http.StatusBadRequest
+	case CodePoWFailed, CodePoWExpired:
+		return http.StatusUnauthorized
+	case CodeRateLimited:
+		return http.StatusTooManyRequests
+	case CodeForbidden:
+		return http.StatusForbidden
+	case CodeNotFound:
+		return http.StatusNotFound
+	case CodeConflict:
+		return http.StatusConflict
+	case CodeUnavailable:
+		return http.StatusServiceUnavailable
+	case CodeMessageTooLarge:
+		return http.StatusRequestEntityTooLarge
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+func writeJSON(w http.ResponseWriter, status int, body any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(body); err != nil {
+		log.Debug().Err(err).Msg("Write JSON")
+	}
+}
+
+// remoteIP extracts IP address from HTTP request
+func remoteIP(r *http.Request) net.IP {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	return net.ParseIP(host)
+}
@@ -0,0 +1,108 @@
+package api
+
+import (
+	"bufio"
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/egsam98/wow/internal/pow"
+)
+
+func TestGateway(t *testing.T) {
+	handler := sliceHandler{{Quote: "q1", Author: "a1"}, {Quote: "q2", Author: "a2"}}
+	puzzle, err := pow.NewPuzzle(func(uint) uint { return 1 })
+	require.NoError(t, err)
+	gateway, err := NewGateway("", handler, puzzle, time.Minute)
+	require.NoError(t, err)
+	srv := httptest.NewServer(gateway)
+	defer srv.Close()
+
+	t.Run("phrase", func(t *testing.T) {
+		res := get(t, srv.URL+"/phrase", "")
+		defer res.Body.Close()
+		require.Equal(t, http.StatusUnauthorized, res.StatusCode)
+		var errRes ErrorResponse
+		require.NoError(t, json.NewDecoder(res.Body).Decode(&errRes))
+		assert.ErrorIs(t, &errRes, ErrPoWFailed)
+
+		auth := solve(t, res.Header.Get("WWW-Authenticate"))
+		res = get(t, srv.URL+"/phrase", auth)
+		defer res.Body.Close()
+		require.Equal(t, http.StatusOK, res.StatusCode)
+		var phrase PhraseResponse
+		require.NoError(t, json.NewDecoder(res.Body).Decode(&phrase))
+		assert.Equal(t, handler[0], phrase)
+
+		// Replay
+		res = get(t, srv.URL+"/phrase", auth)
+		defer res.Body.Close()
+		assert.Equal(t, http.StatusUnauthorized, res.StatusCode)
+	})
+
+	t.Run("phrases", func(t *testing.T) {
+		res := get(t, srv.URL+"/phrases", "")
+		defer res.Body.Close()
+		require.Equal(t, http.StatusUnauthorized, res.StatusCode)
+
+		res = get(t, srv.URL+"/phrases", solve(t, res.Header.Get("WWW-Authenticate")))
+		defer res.Body.Close()
+		require.Equal(t, http.StatusOK, res.StatusCode)
+		var phrases sliceHandler
+		for scanner := bufio.NewScanner(res.Body); scanner.Scan(); {
+			var phrase PhraseResponse
+			require.NoError(t, json.Unmarshal(scanner.Bytes(), &phrase))
+			phrases = append(phrases, phrase)
+		}
+		assert.Equal(t, handler, phrases)
+	})
+
+	t.Run("malformed authorization", func(t *testing.T) {
+		res := get(t, srv.URL+"/phrase", "Basic dXNlcjpwYXNz")
+		defer res.Body.Close()
+		assert.Equal(t, http.StatusBadRequest, res.StatusCode)
+	})
+}
+
+func TestAcceptLanguages(t *testing.T) {
+	assert.Equal(t, []string{"da", "en-GB", "en"}, acceptLanguages("da, en-GB;q=0.8, en;q=0.7"))
+	assert.Equal(t, []string{"fr", "de", "en"}, acceptLanguages("en;q=0.5, *;q=0.9, de;q=0.9, fr, ru;q=0, it;q=x"))
+	assert.Empty(t, acceptLanguages(""))
+}
+
+func get(t *testing.T, url, auth string) *http.Response {
+	t.Helper()
+	req, err := http.NewRequestWithContext(context.Background(), http.MethodGet, url, nil)
+	require.NoError(t, err)
+	if auth != "" {
+		req.Header.Set("Authorization", auth)
+	}
+	res, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	return res
+}
+
+// solve challenge from WWW-Authenticate header returning Authorization header
+func solve(t *testing.T, header string) string {
+	t.Helper()
+	params, ok := parseAuthParams(header)
+	require.True(t, ok)
+	var challenge [pow.ChalLen]byte
+	_, err := hex.Decode(challenge[:], []byte(params["challenge"]))
+	require.NoError(t, err)
+	zeros, err := strconv.ParseUint(params["zeros"], 10, 64)
+	require.NoError(t, err)
+
+	nonce, err := computePoW(context.Background(), challenge, uint(zeros))
+	require.NoError(t, err)
+	return fmt.Sprintf(`PoW token="%s", nonce="%x"`, params["token"], nonce)
+}
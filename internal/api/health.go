@@ -0,0 +1,55 @@
+package api
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/egsam98/errors"
+	"github.com/rs/zerolog/log"
+)
+
+// Health serves probes of orchestrator (e.g. Kubernetes):
+// - GET /livez responds with 200 OK while the process is alive
+// - GET /readyz responds with 200 OK if `ready` succeeds, 503 Service Unavailable with ErrorResponse otherwise.
+// The response is ErrUnavailable unless the error impls PublicError, the rest of errors are logged
+type Health struct {
+	addr  string
+	ready func(context.Context) error
+	mux   *http.ServeMux
+}
+
+func NewHealth(addr string, ready func(context.Context) error) *Health {
+	h := &Health{
+		addr:  addr,
+		ready: ready,
+		mux:   http.NewServeMux(),
+	}
+	h.mux.HandleFunc("GET /livez", func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+	h.mux.HandleFunc("GET /readyz", h.readyz)
+	return h
+}
+
+// Listen serves HTTP requests. The method blocks until the context is canceled
+func (h *Health) Listen(ctx context.Context) error {
+	return errors.Wrap(listenHTTP(ctx, h.addr, h), "listen health")
+}
+
+func (h *Health) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	h.mux.ServeHTTP(w, r)
+}
+
+func (h *Health) readyz(w http.ResponseWriter, r *http.Request) {
+	if err := h.ready(r.Context()); err != nil {
+		var pubErr PublicError
+		if !errors.As(err, &pubErr) {
+			log.Warn().Err(err).Msg("Not ready")
+			writeJSON(w, http.StatusServiceUnavailable, ErrUnavailable)
+			return
+		}
+		writeJSON(w, http.StatusServiceUnavailable, pubErr.Public())
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
@@ -0,0 +1,47 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+
+	"github.com/egsam98/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHealth(t *testing.T) {
+	var err atomic.Pointer[error]
+	setErr := func(e error) { err.Store(&e) }
+	srv := httptest.NewServer(NewHealth("", func(context.Context) error { return *err.Load() }))
+	defer srv.Close()
+
+	res := get(t, srv.URL+"/livez", "")
+	defer res.Body.Close()
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+
+	for _, tc := range []struct {
+		err     error
+		details string
+	}{
+		{err: errors.New("sql: connection refused")},
+		{err: Public(errors.New("empty"), ErrUnavailable.WithDetails("no phrases")), details: "no phrases"},
+	} {
+		setErr(tc.err)
+		res = get(t, srv.URL+"/readyz", "")
+		defer res.Body.Close()
+		require.Equal(t, http.StatusServiceUnavailable, res.StatusCode)
+		var errRes ErrorResponse
+		require.NoError(t, json.NewDecoder(res.Body).Decode(&errRes))
+		assert.ErrorIs(t, &errRes, ErrUnavailable)
+		assert.Equal(t, tc.details, errRes.Details)
+	}
+
+	setErr(nil)
+	res = get(t, srv.URL+"/readyz", "")
+	defer res.Body.Close()
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+}
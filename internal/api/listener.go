@@ -0,0 +1,142 @@
+package api
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"os"
+	"sync/atomic"
+
+	"github.com/egsam98/errors"
+	"github.com/rs/zerolog/log"
+	"golang.org/x/sync/errgroup"
+
+	"github.com/egsam98/wow/internal/pow"
+)
+
+// Listener is an address served by Server with its own access policy. All listeners of the server share
+// the handler, timeouts and message limits
+type Listener struct {
+	// Addr is TCP address or `unix://<path>` of Unix domain socket
+	Addr string
+	// Puzzle overrides server's PoW policy. Nil value keeps server's puzzle
+	Puzzle *pow.Puzzle
+	// PoWBypass grants access without PoW, e.g. for listener of internal network
+	PoWBypass bool
+	// TLS enables TLS on accepted connections if it's set
+	TLS *tls.Config
+	// MaxConns limits concurrent connections of the listener, the exceeding ones are rejected with ErrUnavailable.
+	// Zero value means no limit
+	MaxConns int32
+}
+
+// listener is a Listener with its runtime state
+type listener struct {
+	Listener
+	conns atomic.Int32
+}
+
+// WithListeners makes server listen on `listeners` in addition to the address passed to NewServer
+func WithListeners(listeners ...Listener) ServerOption {
+	return func(s *Server) {
+		for _, l := range listeners {
+			s.listeners = append(s.listeners, &listener{Listener: l})
+		}
+	}
+}
+
+// puzzle returns PoW puzzle of the listener or nil if PoW is bypassed
+func (l *listener) puzzle(fallback *pow.Puzzle) *pow.Puzzle {
+	switch {
+	case l.PoWBypass:
+		return nil
+	case l.Puzzle != nil:
+		return l.Puzzle
+	default:
+		return fallback
+	}
+}
+
+// Listen accepts incoming TCP connections (or Unix domain socket ones for `unix://` address) on all listeners handling
+// them in `Server.handle` method. The method blocks until the context is canceled or any of listeners fails,
+// all listeners are shut down together
+func (s *Server) Listen(ctx context.Context) error {
+	if len(s.listeners) == 0 {
+		return errors.New("no listeners")
+	}
+	g, ctx := errgroup.WithContext(ctx)
+	for _, l := range s.listeners {
+		g.Go(func() error { return s.listen(ctx, l) })
+	}
+	return g.Wait()
+}
+
+func (s *Server) listen(ctx context.Context, l *listener) error {
+	network, addr := splitAddr(l.Addr)
+	if network == "unix" {
+		if err := removeStaleSocket(addr); err != nil {
+			return err
+		}
+	}
+	lis, err := new(net.ListenConfig).Listen(ctx, network, addr)
+	if err != nil {
+		return errors.Wrap(err, "listen server %s", l.Addr)
+	}
+	if network == "unix" && s.unixSocketMode != 0 {
+		if err := os.Chmod(addr, s.unixSocketMode); err != nil {
+			_ = lis.Close()
+			return errors.Wrap(err, "change mode of socket %s", addr)
+		}
+	}
+
+	go func() {
+		<-ctx.Done()
+		if err := lis.Close(); err != nil {
+			log.Err(err).Str("addr", l.Addr).Msg("Close listener")
+		}
+	}()
+
+	for {
+		conn, err := lis.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+			log.Err(err).Str("addr", l.Addr).Msg("Accept connection")
+			continue
+		}
+
+		go s.accept(ctx, l, conn)
+	}
+}
+
+// accept connection of listener: PROXY header is read from trusted proxies, then TLS is applied.
+// Connections exceeding Listener.MaxConns are rejected with ErrUnavailable
+func (s *Server) accept(ctx context.Context, l *listener, conn net.Conn) {
+	n := l.conns.Add(1)
+	defer l.conns.Add(-1)
+
+	if isTrustedProxy(conn, s.trustedProxies) {
+		proxied, err := readProxyHeader(conn, s.timeouts.Read)
+		if err != nil {
+			log.Debug().Err(err).IPAddr("from", ip(conn)).Msg("Read PROXY header")
+			_ = conn.Close()
+			return
+		}
+		conn = proxied
+	}
+	if l.TLS != nil {
+		conn = tls.Server(conn, l.TLS)
+	}
+	if l.MaxConns > 0 && n > l.MaxConns {
+		defer conn.Close()
+		// Writing over TLS starts handshake that reads from client
+		_ = conn.SetDeadline(deadline(s.timeouts.Read))
+		err := ErrUnavailable.WithDetails("too many connections")
+		if err := newServerConn(conn, s.timeouts, s.limits).write(err); err != nil {
+			log.Debug().Err(err).IPAddr("to", ip(conn)).Msg("Write")
+		}
+		return
+	}
+	s.handle(ctx, conn, l.puzzle(s.puzzle))
+}
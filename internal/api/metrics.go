@@ -0,0 +1,9 @@
+package api
+
+import "sync/atomic"
+
+// Metrics contains counters of Server activity. Safe for concurrent use
+type Metrics struct {
+	// Panics is a number of recovered panics in connection handlers
+	Panics atomic.Uint64
+}
@@ -3,6 +3,7 @@ package api
 import (
 	"encoding/binary"
 	"encoding/json"
+	"fmt"
 	"io"
 	"net"
 
@@ -12,10 +13,45 @@ import (
 	"github.com/egsam98/wow/internal/pow"
 )
 
-const maxMessageLen = 1024 // 1KB
+// protocolVersion is checked at handshake, see helloRequest
+const protocolVersion = 1
 
-// write to connection
-func write(conn net.Conn, msg message) error {
+const (
+	// DefaultRequestLen limits frames read by server, i.e. requests
+	DefaultRequestLen = 1024 // 1KB
+	// DefaultResponseLen limits frames written by server, i.e. responses
+	DefaultResponseLen = 64 * 1024 // 64KB
+	// DefaultAdminRequestLen limits requests of clients authorized as admin
+	DefaultAdminRequestLen = 64 * 1024 // 64KB
+)
+
+// MessageLimits limits frame sizes on server side
+type MessageLimits struct {
+	// Request limits incoming frames. They're read before PoW is verified so the limit must be tiny
+	Request uint32
+	// Response limits outgoing frames. The actual limit is negotiated with client at handshake
+	Response uint32
+	// AdminRequest limits incoming frames once client is authorized as admin, since write requests carry whole
+	// phrases with translations. The limit is advertised to client via helloResponse or authResponse
+	AdminRequest uint32
+}
+
+// codec reads and writes frames of single connection according to settings negotiated at handshake
+type codec struct {
+	// readLimit limits incoming frames
+	readLimit uint32
+	// writeLimit limits outgoing frames
+	writeLimit uint32
+	// compression is negotiated compression algorithm. Empty if compression is disabled
+	compression string
+	// compressionThreshold is a minimal size of outgoing frame to compress
+	compressionThreshold uint32
+}
+
+// write to connection.
+// Errors:
+// - ErrMessageTooLarge if frame exceeds writeLimit
+func (c *codec) write(conn net.Conn, msg message) error {
 	msgBytes, err := json.Marshal(msg)
 	if err != nil {
 		return errors.Wrap(err, "marshal %+v", msg)
@@ -27,36 +63,67 @@ func write(conn net.Conn, msg message) error {
 	if err != nil {
 		return errors.Wrap(err, "marshal packet")
 	}
-	if err := binary.Write(conn, binary.LittleEndian, uint32(len(body))); err != nil {
-		return errors.Wrap(err, "write size")
+	if uint32(len(body)) > c.writeLimit {
+		return ErrMessageTooLarge.WithDetails(fmt.Sprintf("%T: %d > %d bytes", msg, len(body), c.writeLimit))
+	}
+	var flags uint32
+	if c.compression != "" && uint32(len(body)) > c.compressionThreshold {
+		if body, err = compress(body); err != nil {
+			return err
+		}
+		flags |= flagCompressed
 	}
-	if _, err := conn.Write(body); err != nil {
+	// Frame is written at once so that message-oriented transports (see Server.ServeWebSocket) carry it in single message
+	frame := binary.LittleEndian.AppendUint32(make([]byte, 0, 4+len(body)), uint32(len(body))|flags)
+	if _, err := conn.Write(append(frame, body...)); err != nil {
 		return errors.Wrap(err, "write")
 	}
 	log.Debug().IPAddr("ip", ip(conn)).Msgf("Write %#v", msg)
 	return nil
 }
 
-// read from connection
-func read(conn net.Conn) (message, error) {
-	var size uint32
-	if err := binary.Read(conn, binary.LittleEndian, &size); err != nil {
+// read from connection.
+// Errors:
+// - ErrMessageTooLarge if frame exceeds readLimit. The connection isn't usable anymore
+func (c *codec) read(conn net.Conn) (message, error) {
+	var header uint32
+	if err := binary.Read(conn, binary.LittleEndian, &header); err != nil {
 		return nil, errors.Wrap(err, "read size")
 	}
-	if size > maxMessageLen {
-		return nil, errors.New("too large message")
+	size := header &^ flagCompressed
+	if size > c.readLimit {
+		return nil, ErrMessageTooLarge.WithDetails(fmt.Sprintf("%d > %d bytes", size, c.readLimit))
 	}
 	buf := make([]byte, size)
 	if _, err := io.ReadFull(conn, buf); err != nil {
 		return nil, errors.Wrap(err, "read")
 	}
+	if header&flagCompressed != 0 {
+		if c.compression == "" {
+			return nil, ErrBadRequest.WithDetails("compressed frame without negotiated compression")
+		}
+		var err error
+		if buf, err = decompress(buf, c.readLimit); err != nil {
+			return nil, err
+		}
+	}
 	var op operation
 	if err := json.Unmarshal(buf, &op); err != nil {
-		return nil, errors.Wrap(err, "unmarshal %s into %T", buf, op)
+		return nil, Public(errors.Wrap(err, "unmarshal %s into %T", buf, op), ErrBadRequest.WithDetails("invalid frame"))
+	}
+
+	msg, err := decode(op.Code, op.Message)
+	if err != nil {
+		return nil, err
 	}
+	log.Debug().IPAddr("ip", ip(conn)).Msgf("Read %#v", msg)
+	return msg, nil
+}
 
+// decode message corresponding to op code
+func decode(code opCode, data json.RawMessage) (message, error) {
 	var msg message
-	switch op.Code {
+	switch code {
 	case powNonceReq:
 		msg = new(powNonceRequest)
 	case powChallengeResp:
@@ -65,19 +132,49 @@ func read(conn net.Conn) (message, error) {
 		msg = new(ErrorResponse)
 	case streamTombstoneResp:
 		msg = new(streamTombstoneResponse)
+	case cancelStreamReq:
+		msg = new(cancelStreamRequest)
+	case streamCreditReq:
+		msg = new(streamCreditRequest)
+	case helloReq:
+		msg = new(helloRequest)
+	case helloResp:
+		msg = new(helloResponse)
+	case authReq:
+		msg = new(authRequest)
+	case authResp:
+		msg = new(authResponse)
+	case createPhraseReq:
+		msg = new(CreatePhraseRequest)
+	case updatePhraseReq:
+		msg = new(UpdatePhraseRequest)
+	case deletePhraseReq:
+		msg = new(DeletePhraseRequest)
+	case deletePhraseResp:
+		msg = new(deletePhraseResponse)
+	case pingReq:
+		msg = new(pingRequest)
+	case pongResp:
+		msg = new(pongResponse)
 	case phraseReq:
 		msg = new(PhraseRequest)
 	case phraseResp:
 		msg = new(PhraseResponse)
+	case getPhraseReq:
+		msg = new(GetPhraseRequest)
 	case allPhrasesReq:
 		msg = new(AllPhrasesRequest)
+	case searchPhrasesReq:
+		msg = new(SearchPhrasesRequest)
+	case streamBatchResp:
+		msg = new(streamBatchResponse)
 	default:
-		return nil, errors.Errorf("unexpected command: %s", op.Code)
+		return nil, ErrBadRequest.WithDetails(fmt.Sprintf("unexpected command %q", code))
 	}
-	if err := json.Unmarshal(op.Message, msg); err != nil {
-		return nil, errors.Wrap(err, "unmarshal packet message %s into %T", op.Message, msg)
+	if err := json.Unmarshal(data, msg); err != nil {
+		return nil, Public(errors.Wrap(err, "unmarshal packet message %s into %T", data, msg),
+			ErrBadRequest.WithDetails(fmt.Sprintf("invalid message of %s", code)))
 	}
-	log.Debug().IPAddr("ip", ip(conn)).Msgf("Read %#v", msg)
 	return msg, nil
 }
 
@@ -88,10 +185,25 @@ const (
 	powNonceReq         opCode = "pow_nonce_req"
 	powChallengeResp    opCode = "pow_challenge_resp"
 	streamTombstoneResp opCode = "stream_tombstone_resp"
+	cancelStreamReq     opCode = "cancel_stream_req"
+	streamCreditReq     opCode = "stream_credit_req"
+	streamBatchResp     opCode = "stream_batch_resp"
 	errorResp           opCode = "error_resp"
+	helloReq            opCode = "hello_req"
+	helloResp           opCode = "hello_resp"
+	authReq             opCode = "auth_req"
+	authResp            opCode = "auth_resp"
+	createPhraseReq     opCode = "create_phrase_req"
+	updatePhraseReq     opCode = "update_phrase_req"
+	deletePhraseReq     opCode = "delete_phrase_req"
+	deletePhraseResp    opCode = "delete_phrase_resp"
+	pingReq             opCode = "ping_req"
+	pongResp            opCode = "pong_resp"
 	phraseReq           opCode = "phrase_req"
 	phraseResp          opCode = "phrase_resp"
+	getPhraseReq        opCode = "get_phrase_req"
 	allPhrasesReq       opCode = "all_phrases_req"
+	searchPhrasesReq    opCode = "search_phrases_req"
 )
 
 // operation is primary DTO that is transferred in TCP connection
@@ -117,28 +229,173 @@ type powChallengeResponse struct {
 
 func (*powChallengeResponse) opCode() opCode { return powChallengeResp }
 
-type streamTombstoneResponse struct{}
+// streamTombstoneResponse ends stream
+type streamTombstoneResponse struct {
+	// NextCursor is a cursor of the next page of paginated stream (see Page). Empty if the page is the last one
+	NextCursor string `json:"next_cursor,omitempty"`
+}
 
 func (*streamTombstoneResponse) opCode() opCode { return streamTombstoneResp }
 
-type ErrorResponse struct {
-	Message string `json:"message"`
+type cancelStreamRequest struct{}
+
+func (*cancelStreamRequest) opCode() opCode { return cancelStreamReq }
+
+// streamBatchResponse carries several stream items of the same op code in single frame
+type streamBatchResponse struct {
+	Code  opCode            `json:"code"`
+	Items []json.RawMessage `json:"items"`
+}
+
+func (*streamBatchResponse) opCode() opCode { return streamBatchResp }
+
+type streamCreditRequest struct {
+	Credits uint `json:"credits"`
+}
+
+func (*streamCreditRequest) opCode() opCode { return streamCreditReq }
+
+// helloRequest is an optional handshake. It's the first request of the connection that doesn't require PoW.
+// Without handshake server writes frames limited by MessageLimits.Request
+type helloRequest struct {
+	Version uint `json:"version"`
+	// MaxMessageLen limits frames client is ready to read
+	MaxMessageLen uint32 `json:"max_message_len"`
+	// Compression lists compression algorithms supported by client
+	Compression []string `json:"compression,omitempty"`
+}
+
+func (*helloRequest) opCode() opCode { return helloReq }
+
+type helloResponse struct {
+	Version uint `json:"version"`
+	// MaxMessageLen is a negotiated limit of frames written by server
+	MaxMessageLen uint32 `json:"max_message_len"`
+	// MaxRequestLen limits frames written by client
+	MaxRequestLen uint32 `json:"max_request_len"`
+	// Compression is an algorithm chosen by server. Empty if compression is disabled
+	Compression string `json:"compression,omitempty"`
+	// CompressionThreshold is a minimal size of frame to be compressed by both sides
+	CompressionThreshold uint32 `json:"compression_threshold,omitempty"`
 }
 
-func (e *ErrorResponse) Error() string { return e.Message }
-func (*ErrorResponse) opCode() opCode  { return errorResp }
+func (*helloResponse) opCode() opCode { return helloResp }
 
-type PhraseRequest struct{}
+// authRequest authorizes connection as admin. Like handshake, it doesn't require PoW
+type authRequest struct {
+	Token string `json:"token"`
+}
+
+func (*authRequest) opCode() opCode { return authReq }
+
+type authResponse struct {
+	// MaxRequestLen limits frames written by client authorized as admin, see MessageLimits.AdminRequest
+	MaxRequestLen uint32 `json:"max_request_len"`
+}
+
+func (*authResponse) opCode() opCode { return authResp }
+
+type pingRequest struct{}
+
+func (*pingRequest) opCode() opCode { return pingReq }
+
+type pongResponse struct{}
+
+func (*pongResponse) opCode() opCode { return pongResp }
+
+// PhraseRequest is responded with random PhraseResponse matching the filters or ErrNotFound if there are no such phrases
+type PhraseRequest struct {
+	// Tag limits phrases to the theme (e.g. motivation, humor). Empty tag matches all phrases
+	Tag string `json:"tag,omitempty"`
+	// Languages are BCP 47 tags (e.g. "pt-BR") in order of preference. The phrase is responded in the first of them
+	// it's translated to, in its original language otherwise
+	Languages []string `json:"languages,omitempty"`
+}
 
 func (*PhraseRequest) opCode() opCode { return phraseReq }
 
 type PhraseResponse struct {
-	Quote  string `json:"quote"`
-	Author string `json:"author"`
+	// ID is a stable identifier of the phrase, see GetPhraseRequest
+	ID     string   `json:"id"`
+	Quote  string   `json:"quote"`
+	Author string   `json:"author"`
+	Tags   []string `json:"tags,omitempty"`
+	// Language is BCP 47 tag of quote and author. Empty language is undetermined
+	Language string `json:"language,omitempty"`
 }
 
 func (*PhraseResponse) opCode() opCode { return phraseResp }
 
-type AllPhrasesRequest struct{}
+// GetPhraseRequest is responded with PhraseResponse of the ID or ErrNotFound
+type GetPhraseRequest struct {
+	ID string `json:"id"`
+}
+
+func (*GetPhraseRequest) opCode() opCode { return getPhraseReq }
+
+// AllPhrasesRequest streams phrases in stable order
+type AllPhrasesRequest struct {
+	StreamRequest
+	// PageSize limits number of phrases of the stream. Zero value streams all phrases
+	PageSize uint `json:"page_size,omitempty"`
+	// Cursor is an opaque position of the page received in the tail of the previous one. Empty for the first page
+	Cursor string `json:"cursor,omitempty"`
+	// Languages are preferred languages of phrases, see PhraseRequest.Languages
+	Languages []string `json:"languages,omitempty"`
+}
 
 func (*AllPhrasesRequest) opCode() opCode { return allPhrasesReq }
+
+// SearchPhrasesRequest streams phrases matching all non-empty filters
+type SearchPhrasesRequest struct {
+	StreamRequest
+	// Author matches author's name case-insensitively
+	Author string `json:"author,omitempty"`
+	// Keyword matches substring of quote case-insensitively
+	Keyword string `json:"keyword,omitempty"`
+	// Limit limits number of phrases. Zero value means no limit
+	Limit uint `json:"limit,omitempty"`
+}
+
+func (*SearchPhrasesRequest) opCode() opCode { return searchPhrasesReq }
+
+// CreatePhraseRequest adds phrase. It's responded with PhraseResponse, admin authorization is required
+type CreatePhraseRequest struct {
+	Quote        string                 `json:"quote"`
+	Author       string                 `json:"author"`
+	Tags         []string               `json:"tags,omitempty"`
+	Language     string                 `json:"language,omitempty"`
+	Translations map[string]Translation `json:"translations,omitempty"`
+}
+
+func (*CreatePhraseRequest) opCode() opCode { return createPhraseReq }
+
+// UpdatePhraseRequest replaces content of the phrase keeping its ID. It's responded with PhraseResponse,
+// admin authorization is required
+type UpdatePhraseRequest struct {
+	ID           string                 `json:"id"`
+	Quote        string                 `json:"quote"`
+	Author       string                 `json:"author"`
+	Tags         []string               `json:"tags,omitempty"`
+	Language     string                 `json:"language,omitempty"`
+	Translations map[string]Translation `json:"translations,omitempty"`
+}
+
+// Translation is a phrase in another language
+type Translation struct {
+	Quote  string `json:"quote"`
+	Author string `json:"author"`
+}
+
+func (*UpdatePhraseRequest) opCode() opCode { return updatePhraseReq }
+
+// DeletePhraseRequest deletes phrase. Admin authorization is required
+type DeletePhraseRequest struct {
+	ID string `json:"id"`
+}
+
+func (*DeletePhraseRequest) opCode() opCode { return deletePhraseReq }
+
+type deletePhraseResponse struct{}
+
+func (*deletePhraseResponse) opCode() opCode { return deletePhraseResp }
@@ -0,0 +1,152 @@
+package api
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"io"
+	"net"
+	"net/netip"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/egsam98/errors"
+)
+
+// proxyV2Signature prefixes binary header of PROXY protocol v2
+var proxyV2Signature = []byte("\r\n\r\n\x00\r\nQUIT\n")
+
+// proxyV1MaxLen is a maximum length of text header of PROXY protocol v1 including CRLF
+const proxyV1MaxLen = 107
+
+// proxyConn is a connection accepted from proxy. RemoteAddr returns address of real client
+type proxyConn struct {
+	net.Conn
+	r      *bufio.Reader
+	remote net.Addr
+}
+
+func (c *proxyConn) Read(b []byte) (int, error) { return c.r.Read(b) }
+
+func (c *proxyConn) RemoteAddr() net.Addr { return c.remote }
+
+// isTrustedProxy reports whether connection comes from one of trusted proxies
+func isTrustedProxy(conn net.Conn, trusted []netip.Prefix) bool {
+	addr, ok := conn.RemoteAddr().(*net.TCPAddr)
+	if !ok {
+		return false
+	}
+	ip := addr.AddrPort().Addr().Unmap()
+	for _, prefix := range trusted {
+		if prefix.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// readProxyHeader reads PROXY protocol (v1 or v2) header in `timeout` and returns connection reporting real client's
+// address. The proxy's address is kept for UNKNOWN/LOCAL headers (e.g. health checks of the proxy)
+func readProxyHeader(conn net.Conn, timeout time.Duration) (net.Conn, error) {
+	_ = conn.SetReadDeadline(deadline(timeout))
+	defer conn.SetReadDeadline(time.Time{}) //nolint:errcheck
+
+	r := bufio.NewReaderSize(conn, 256)
+	prefix, err := r.Peek(len(proxyV2Signature))
+	if err != nil {
+		return nil, errors.Wrap(err, "read PROXY header")
+	}
+
+	var remote net.Addr
+	switch {
+	case bytes.Equal(prefix, proxyV2Signature):
+		remote, err = readProxyV2(r)
+	case bytes.HasPrefix(prefix, []byte("PROXY ")):
+		remote, err = readProxyV1(r)
+	default:
+		return nil, errors.New("PROXY header is expected")
+	}
+	if err != nil {
+		return nil, err
+	}
+	if remote == nil {
+		remote = conn.RemoteAddr()
+	}
+	return &proxyConn{Conn: conn, r: r, remote: remote}, nil
+}
+
+// readProxyV1 parses text header, e.g. `PROXY TCP4 192.168.0.1 192.168.0.11 56324 443\r\n`
+func readProxyV1(r *bufio.Reader) (net.Addr, error) {
+	line, err := r.ReadSlice('\n')
+	if err != nil {
+		return nil, errors.Wrap(err, "read PROXY v1 header")
+	}
+	if len(line) > proxyV1MaxLen || !bytes.HasSuffix(line, []byte("\r\n")) {
+		return nil, errors.New("malformed PROXY v1 header")
+	}
+	fields := strings.Fields(string(line[:len(line)-2]))
+	if len(fields) >= 2 && fields[1] == "UNKNOWN" {
+		return nil, nil
+	}
+	if len(fields) != 6 || (fields[1] != "TCP4" && fields[1] != "TCP6") {
+		return nil, errors.Errorf("malformed PROXY v1 header %q", line)
+	}
+	ip, err := netip.ParseAddr(fields[2])
+	if err != nil {
+		return nil, errors.Wrap(err, "parse PROXY v1 source address")
+	}
+	port, err := strconv.ParseUint(fields[4], 10, 16)
+	if err != nil {
+		return nil, errors.Wrap(err, "parse PROXY v1 source port")
+	}
+	return net.TCPAddrFromAddrPort(netip.AddrPortFrom(ip, uint16(port))), nil
+}
+
+// readProxyV2 parses binary header
+func readProxyV2(r *bufio.Reader) (net.Addr, error) {
+	var header [16]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return nil, errors.Wrap(err, "read PROXY v2 header")
+	}
+	if version := header[12] >> 4; version != 2 {
+		return nil, errors.Errorf("unsupported PROXY protocol version %d", version)
+	}
+	payload := make([]byte, binary.BigEndian.Uint16(header[14:]))
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return nil, errors.Wrap(err, "read PROXY v2 addresses")
+	}
+
+	const (
+		cmdLocal = 0x0
+		cmdProxy = 0x1
+		afInet   = 0x1
+		afInet6  = 0x2
+	)
+	switch cmd := header[12] & 0x0F; cmd {
+	case cmdLocal:
+		return nil, nil
+	case cmdProxy:
+	default:
+		return nil, errors.Errorf("unsupported PROXY v2 command %#x", cmd)
+	}
+
+	// Addresses block: source address, destination address, source port, destination port
+	switch family := header[13] >> 4; family {
+	case afInet:
+		if len(payload) < 12 {
+			return nil, errors.New("malformed PROXY v2 IPv4 addresses")
+		}
+		ip := netip.AddrFrom4([4]byte(payload[:4]))
+		return net.TCPAddrFromAddrPort(netip.AddrPortFrom(ip, binary.BigEndian.Uint16(payload[8:]))), nil
+	case afInet6:
+		if len(payload) < 36 {
+			return nil, errors.New("malformed PROXY v2 IPv6 addresses")
+		}
+		ip := netip.AddrFrom16([16]byte(payload[:16]))
+		return net.TCPAddrFromAddrPort(netip.AddrPortFrom(ip, binary.BigEndian.Uint16(payload[32:]))), nil
+	default:
+		// AF_UNSPEC or AF_UNIX: the proxy's address is kept
+		return nil, nil
+	}
+}
@@ -0,0 +1,64 @@
+package api
+
+import (
+	"encoding/binary"
+	"io"
+	"net"
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReadProxyHeader(t *testing.T) {
+	v2 := func(cmd, family byte, addrs []byte) []byte {
+		header := append([]byte{}, proxyV2Signature...)
+		header = append(header, 0x20|cmd, family<<4|0x1)
+		header = binary.BigEndian.AppendUint16(header, uint16(len(addrs)))
+		return append(header, addrs...)
+	}
+	ipv4 := []byte{192, 168, 0, 1, 10, 0, 0, 1, 0xdc, 0x04, 0x01, 0xbb}
+	ipv6 := append(netip.MustParseAddr("2001:db8::1").AsSlice(), netip.MustParseAddr("2001:db8::2").AsSlice()...)
+	ipv6 = append(ipv6, 0xdc, 0x04, 0x01, 0xbb)
+
+	for _, tc := range []struct {
+		name   string
+		header []byte
+		exp    string
+	}{
+		{name: "v1 TCP4", header: []byte("PROXY TCP4 192.168.0.1 10.0.0.1 56324 443\r\n"), exp: "192.168.0.1:56324"},
+		{name: "v1 TCP6", header: []byte("PROXY TCP6 2001:db8::1 2001:db8::2 56324 443\r\n"), exp: "[2001:db8::1]:56324"},
+		{name: "v1 UNKNOWN", header: []byte("PROXY UNKNOWN\r\n"), exp: "pipe"},
+		{name: "v2 IPv4", header: v2(0x1, 0x1, ipv4), exp: "192.168.0.1:56324"},
+		{name: "v2 IPv6", header: v2(0x1, 0x2, ipv6), exp: "[2001:db8::1]:56324"},
+		{name: "v2 LOCAL", header: v2(0x0, 0x0, nil), exp: "pipe"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			server, client := net.Pipe()
+			defer server.Close()
+			defer client.Close()
+			go func() { _, _ = client.Write(append(tc.header, "payload"...)) }()
+
+			conn, err := readProxyHeader(server, time.Second)
+			require.NoError(t, err)
+			assert.Equal(t, tc.exp, conn.RemoteAddr().String())
+
+			payload := make([]byte, len("payload"))
+			_, err = io.ReadFull(conn, payload)
+			require.NoError(t, err)
+			assert.Equal(t, "payload", string(payload))
+		})
+	}
+
+	t.Run("missing header", func(t *testing.T) {
+		server, client := net.Pipe()
+		defer server.Close()
+		defer client.Close()
+		go func() { _, _ = client.Write([]byte("GET / HTTP/1.1\r\n\r\n")) }()
+
+		_, err := readProxyHeader(server, time.Second)
+		assert.Error(t, err)
+	})
+}
@@ -6,7 +6,9 @@ import (
 	"io"
 	"iter"
 	"net"
+	"net/netip"
 	"os"
+	"slices"
 	"sync/atomic"
 	"time"
 
@@ -18,90 +20,183 @@ import (
 
 // Server serves TCP connection
 type Server struct {
-	addr        string
-	tcpDeadline time.Duration
-	handler     ServerHandler
-	puzzle      *pow.Puzzle
-	conns       atomic.Int32
+	listeners []*listener
+	timeouts  Timeouts
+	limits    MessageLimits
+	// compressionThreshold enables compression of frames larger than the threshold if it's positive
+	compressionThreshold uint32
+	// wsOrigins are allowed origins of WebSocket connections besides the host itself
+	wsOrigins []string
+	// unixSocketMode is a file mode of Unix domain socket. Zero value keeps mode defined by umask
+	unixSocketMode os.FileMode
+	// localPoWBypass disables PoW for peers connected via Unix domain socket
+	localPoWBypass bool
+	// trustedProxies are networks of proxies that prepend connections with PROXY protocol header
+	trustedProxies []netip.Prefix
+	// admin authorizes write operations, see WithAdmin
+	admin   Admin
+	handler ServerHandler
+	puzzle  *pow.Puzzle
+	conns   atomic.Int32
+	metrics Metrics
 }
 
+// ServerHandler handles requests passed PoW.
+// Returned errors are sent to client only if they impl PublicError, otherwise they are logged and hidden behind ErrInternal
 type ServerHandler interface {
 	Phrase(context.Context, *PhraseRequest) (*PhraseResponse, error)
-	AllPhrases(context.Context, *AllPhrasesRequest) iter.Seq2[*PhraseResponse, error]
+	GetPhrase(context.Context, *GetPhraseRequest) (*PhraseResponse, error)
+	AllPhrases(context.Context, *AllPhrasesRequest) Page[*PhraseResponse]
+	SearchPhrases(context.Context, *SearchPhrasesRequest) iter.Seq2[*PhraseResponse, error]
 }
 
-func NewServer(addr string, tcpDeadline time.Duration, handler ServerHandler, puzzle *pow.Puzzle) Server {
-	return Server{
-		handler:     handler,
-		addr:        addr,
-		tcpDeadline: tcpDeadline,
-		puzzle:      puzzle,
+type ServerOption func(*Server)
+
+// WithMessageLimits overrides DefaultRequestLen, DefaultResponseLen and DefaultAdminRequestLen
+func WithMessageLimits(limits MessageLimits) ServerOption {
+	return func(s *Server) {
+		s.limits = limits
 	}
 }
 
-// Listen accepts incoming TCP connections handling them in `Server.handle` method.
-// The method blocks until the context is canceled
-func (s *Server) Listen(ctx context.Context) error {
-	lis, err := new(net.ListenConfig).Listen(ctx, "tcp", s.addr)
-	if err != nil {
-		return errors.Wrap(err, "listen server")
+// WithCompressionThreshold compresses frames larger than `threshold` bytes for clients supporting CompressionFlate
+func WithCompressionThreshold(threshold uint32) ServerOption {
+	return func(s *Server) {
+		s.compressionThreshold = threshold
 	}
+}
 
-	go func() {
-		<-ctx.Done()
-		if err := lis.Close(); err != nil {
-			log.Err(err).Msg("Close listener")
-		}
-	}()
+// WithWebSocketOrigins allows cross-origin WebSocket connections from hosts matching patterns (see path.Match)
+func WithWebSocketOrigins(patterns ...string) ServerOption {
+	return func(s *Server) {
+		s.wsOrigins = patterns
+	}
+}
 
-	for {
-		conn, err := lis.Accept()
-		if err != nil {
-			if errors.Is(err, net.ErrClosed) {
-				return nil
-			}
-			log.Err(err).Msg("Accept TCP connection")
-			continue
-		}
+// WithUnixSocketMode sets file mode of Unix domain socket listened by the server
+func WithUnixSocketMode(mode os.FileMode) ServerOption {
+	return func(s *Server) {
+		s.unixSocketMode = mode
+	}
+}
 
-		go s.handle(ctx, conn)
+// WithLocalPoWBypass grants access without PoW to peers connected via Unix domain socket
+func WithLocalPoWBypass() ServerOption {
+	return func(s *Server) {
+		s.localPoWBypass = true
 	}
 }
 
+// WithTrustedProxies expects PROXY protocol (v1 or v2) header from connections originated from `proxies` networks,
+// so that real client's address is used in logs and PoW
+func WithTrustedProxies(proxies ...netip.Prefix) ServerOption {
+	return func(s *Server) {
+		s.trustedProxies = proxies
+	}
+}
+
+// NewServer creates server listening on `addr` with `puzzle` as PoW policy. Empty `addr` is allowed if listeners are
+// passed via WithListeners or the server serves WebSocket connections only
+func NewServer(addr string, timeouts Timeouts, handler ServerHandler, puzzle *pow.Puzzle, opts ...ServerOption) *Server {
+	s := &Server{
+		handler:  handler,
+		timeouts: timeouts,
+		limits: MessageLimits{
+			Request:      DefaultRequestLen,
+			Response:     DefaultResponseLen,
+			AdminRequest: DefaultAdminRequestLen,
+		},
+		puzzle: puzzle,
+	}
+	if addr != "" {
+		s.listeners = append(s.listeners, &listener{Listener: Listener{Addr: addr}})
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
+}
+
+// Metrics returns counters collected while serving connections
+func (s *Server) Metrics() *Metrics { return &s.metrics }
+
 func (s *Server) Close() {
 	for s.conns.Load() > 0 {
 		continue
 	}
 }
 
-// handle connection in separate loop
-func (s *Server) handle(ctx context.Context, conn net.Conn) {
+// handle connection in separate loop. Nil `puzzle` grants access without PoW
+func (s *Server) handle(ctx context.Context, netConn net.Conn, puzzle *pow.Puzzle) {
 	s.conns.Add(1)
 	defer s.conns.Add(-1)
-	defer conn.Close()
+	defer netConn.Close()
+	conn := newServerConn(netConn, s.timeouts, s.limits)
+	defer s.recover(conn)
 
+	// The first request is expected right after connecting, the next ones may come after idle period
+	wait := s.timeouts.Read
+	// granted is time of the last granted request, see Timeouts.Keepalive
+	granted := time.Now()
 	handle := func() error {
-		_ = conn.SetDeadline(time.Now().Add(s.tcpDeadline))
+		if s.timeouts.Keepalive > 0 {
+			left := time.Until(granted.Add(s.timeouts.Keepalive))
+			if left <= 0 {
+				return errors.Wrap(os.ErrDeadlineExceeded, "no granted requests for %s", s.timeouts.Keepalive)
+			}
+			if wait <= 0 || left < wait {
+				wait = left
+			}
+		}
+		conn.awaitRequest(wait)
+		wait = s.timeouts.Idle
 
-		msg, err := read(conn)
+		msg, err := conn.readRequest()
 		if err != nil {
 			return err
 		}
+		switch msg := msg.(type) {
+		// Handshake and keepalive don't require PoW
+		case *helloRequest:
+			return s.hello(conn, msg)
+		case *pingRequest:
+			return conn.write(new(pongResponse))
+		// Write operations are authorized by admin credential instead of PoW
+		case *authRequest:
+			return s.auth(conn, msg)
+		case *CreatePhraseRequest, *UpdatePhraseRequest, *DeletePhraseRequest:
+			if s.isAdmin(conn) {
+				granted = time.Now()
+			}
+			return s.manage(ctx, conn, msg)
+		// Control messages of already finished stream
+		case *cancelStreamRequest, *streamCreditRequest:
+			return nil
+		}
 
-		if err := s.requestPoW(conn); err != nil {
-			return err
+		if puzzle != nil && (!s.localPoWBypass || !isLocal(conn)) {
+			if err := s.requestPoW(conn, puzzle); err != nil {
+				return err
+			}
 		}
+		granted = time.Now()
 
 		var res message
 		switch msg := msg.(type) {
 		case *PhraseRequest:
 			res, err = s.handler.Phrase(ctx, msg)
 			return respond(conn, res, err)
+		case *GetPhraseRequest:
+			res, err = s.handler.GetPhrase(ctx, msg)
+			return respond(conn, res, err)
 		case *AllPhrasesRequest:
-			it := s.handler.AllPhrases(ctx, msg)
-			return respondStream(conn, it)
+			page := s.handler.AllPhrases(ctx, msg)
+			return respondStream(conn, msg, page.Items, page.Next)
+		case *SearchPhrasesRequest:
+			it := s.handler.SearchPhrases(ctx, msg)
+			return respondStream(conn, msg, it, nil)
 		default:
-			return write(conn, &ErrorResponse{Message: fmt.Sprintf("unexpected message %v (%T)", msg, msg)})
+			return conn.write(ErrBadRequest.WithDetails(fmt.Sprintf("unexpected message %v (%T)", msg, msg)))
 		}
 	}
 
@@ -110,64 +205,175 @@ func (s *Server) handle(ctx context.Context, conn net.Conn) {
 		case err == nil:
 			continue
 		case errors.Is(err, io.EOF):
-		case errors.Is(err, os.ErrDeadlineExceeded):
+		case isTimeout(err):
 			log.Debug().Err(err).IPAddr("from", ip(conn)).Msg("Deadline timeout")
 		default:
-			if err := write(conn, &ErrorResponse{Message: "internal error"}); err != nil {
+			if err := conn.write(toErrorResponse(ip(conn), err)); err != nil {
 				log.Err(err).IPAddr("to", ip(conn)).Msg("Write")
 			}
-			log.Err(err).IPAddr("from", ip(conn)).Msg("Handle")
 		}
 		return
 	}
 }
 
-// requestPoW requests Proof of Work from connection before granting access to resource
-func (s *Server) requestPoW(conn net.Conn) error {
-	challenge, zeros, err := s.puzzle.Challenge(uint(s.conns.Load()))
+// recover intercepts panic occurred while handling connection so that it doesn't take down the whole server.
+// The panic is logged with stack trace and reported to client as internal error with correlation ID
+func (s *Server) recover(conn *serverConn) {
+	r := recover()
+	if r == nil {
+		return
+	}
+	s.metrics.Panics.Add(1)
+	err, ok := r.(error)
+	if !ok {
+		err = errors.Errorf("%v", r)
+	}
+	if err := conn.write(toErrorResponse(ip(conn), errors.Wrap(err, "panic"))); err != nil {
+		log.Err(err).IPAddr("to", ip(conn)).Msg("Write")
+	}
+}
+
+// hello negotiates connection settings.
+// Errors:
+// - ErrVersionMismatch if client's protocol version isn't supported
+func (s *Server) hello(conn *serverConn, req *helloRequest) error {
+	if req.Version != protocolVersion {
+		return ErrVersionMismatch.WithDetails(fmt.Sprintf("server supports version %d", protocolVersion))
+	}
+	limit := s.limits.Response
+	if req.MaxMessageLen > 0 {
+		limit = min(limit, req.MaxMessageLen)
+	}
+	res := helloResponse{
+		Version:       protocolVersion,
+		MaxMessageLen: limit,
+		MaxRequestLen: conn.codec.readLimit,
+	}
+	// Admin identity of TLS client certificate is known since handshake
+	if s.admin.Handler != nil && s.isAdmin(conn) {
+		res.MaxRequestLen = s.grantAdmin(conn)
+	}
+	if s.compressionThreshold > 0 && slices.Contains(req.Compression, CompressionFlate) {
+		res.Compression = CompressionFlate
+		res.CompressionThreshold = s.compressionThreshold
+	}
+	conn.codec.writeLimit = limit
+	if err := conn.write(&res); err != nil {
+		return err
+	}
+	// Compression is applied after client has received the settings
+	conn.codec.compression = res.Compression
+	conn.codec.compressionThreshold = res.CompressionThreshold
+	return nil
+}
+
+// requestPoW requests Proof of Work from connection before granting access to resource.
+// Errors:
+// - ErrBadRequest if client responds with anything except powNonceRequest
+// - ErrPoWFailed if nonce isn't verified
+// - ErrPoWExpired if nonce isn't received in Timeouts.PoW
+func (s *Server) requestPoW(conn *serverConn, puzzle *pow.Puzzle) error {
+	challenge, zeros, err := puzzle.Challenge(uint(s.conns.Load()))
 	if err != nil {
 		return err
 	}
-	if err := write(conn, &powChallengeResponse{Challenge: challenge, Zeros: zeros}); err != nil {
+	if err := conn.write(&powChallengeResponse{Challenge: challenge, Zeros: zeros}); err != nil {
 		return err
 	}
 
-	msg, err := read(conn)
+	conn.awaitPoW()
+	msg, err := conn.read()
 	if err != nil {
+		if isTimeout(err) {
+			return ErrPoWExpired
+		}
 		return err
 	}
 	req, ok := msg.(*powNonceRequest)
 	if !ok {
-		return write(conn, &ErrorResponse{Message: "powNonceRequest is expected"})
+		return ErrBadRequest.WithDetails("powNonceRequest is expected")
 	}
 
 	if err := pow.Verify(challenge, zeros, req.Nonce); err != nil {
-		return write(conn, &ErrorResponse{Message: err.Error()})
+		if errors.Is(err, pow.ErrVerify) {
+			return ErrPoWFailed
+		}
+		return err
 	}
 	return nil
 }
 
-func respond[T message](conn net.Conn, res T, err error) error {
+func respond[T message](conn *serverConn, res T, err error) error {
 	if err != nil {
-		return write(conn, &ErrorResponse{Message: err.Error()})
+		return conn.write(toErrorResponse(ip(conn), err))
 	}
-	return write(conn, res)
+	return conn.write(res)
 }
 
-func respondStream[T message](conn net.Conn, it iter.Seq2[T, error]) error {
+// respondStream writes items of iterator followed by stream's tombstone. Items are packed into frames according to
+// StreamRequest.BatchSize. Every frame requires a credit granted by client (see StreamRequest.Window), waiting for
+// credits is limited by Timeouts.Idle. The iterator is stopped as soon as client sends cancelStreamRequest,
+// the tombstone acknowledges cancellation. Otherwise, the tombstone carries cursor returned by optional `next`
+func respondStream[T message](conn *serverConn, req streamMessage, it iter.Seq2[T, error], next func() string) error {
+	opts := req.stream()
+	ctrl := controlStream(conn, opts.Window)
+	defer ctrl.stop()
+
+	batch := newStreamBatch(opts.BatchSize, conn.codec.writeLimit, func(msg message) error {
+		if err := ctrl.acquire(conn.timeouts.Idle); err != nil {
+			return err
+		}
+		return conn.write(msg)
+	})
+	tombstone := func(err error) error {
+		var res streamTombstoneResponse
+		switch {
+		case errors.Is(err, errStreamCanceled):
+		case err != nil:
+			return err
+		case next != nil:
+			res.NextCursor = next()
+		}
+		return conn.write(&res)
+	}
+
 	for res, err := range it {
 		if err != nil {
-			return write(conn, &ErrorResponse{Message: err.Error()})
+			if err := batch.flush(); err != nil {
+				return tombstone(err)
+			}
+			return conn.write(toErrorResponse(ip(conn), err))
 		}
-		if err := write(conn, res); err != nil {
-			return err
+		if err := batch.add(res); err != nil {
+			return tombstone(err)
 		}
 	}
-	return write(conn, new(streamTombstoneResponse))
+	return tombstone(batch.flush())
+}
+
+// isTimeout reports whether err is caused by connection deadline.
+// WebSocket connections report deadlines as context.DeadlineExceeded
+func isTimeout(err error) bool {
+	return errors.Is(err, os.ErrDeadlineExceeded) || errors.Is(err, context.DeadlineExceeded)
 }
 
-// ip extracts IP address from net.Conn
-// Panics if address is not *net.TCPAddr
+// ip extracts IP address from net.Conn.
+// Returns nil if remote address doesn't carry IP (e.g. Unix domain socket)
 func ip(conn net.Conn) net.IP {
-	return conn.RemoteAddr().(*net.TCPAddr).IP
+	switch addr := conn.RemoteAddr().(type) {
+	case nil:
+		return nil
+	case *net.TCPAddr:
+		return addr.IP
+	case *net.UDPAddr:
+		return addr.IP
+	case *net.IPAddr:
+		return addr.IP
+	default:
+		host, _, err := net.SplitHostPort(addr.String())
+		if err != nil {
+			host = addr.String()
+		}
+		return net.ParseIP(host)
+	}
 }
@@ -0,0 +1,838 @@
+package api
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/binary"
+	"iter"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/egsam98/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/egsam98/wow/internal/pow"
+)
+
+func TestServer_PanicRecovery(t *testing.T) {
+	srv := startServer(t, new(panicHandler), testTimeouts)
+	client := dial(t, srv)
+
+	_, err := client.Phrase(context.Background(), PhraseRequest{})
+	require.ErrorIs(t, err, ErrInternal)
+	assert.EqualValues(t, 1, srv.Metrics().Panics.Load())
+
+	client = dial(t, srv)
+	for _, err := range client.AllPhrases(context.Background()) {
+		require.ErrorIs(t, err, ErrInternal)
+	}
+	assert.EqualValues(t, 2, srv.Metrics().Panics.Load())
+}
+
+func TestServer_ErrorResponse(t *testing.T) {
+	internal := errors.New("sql: connection refused")
+
+	for _, tc := range []struct {
+		name string
+		err  error
+		exp  *ErrorResponse
+	}{
+		{name: "private", err: internal, exp: ErrInternal},
+		{name: "public", err: ErrNotFound.WithDetails("no phrases"), exp: ErrNotFound},
+		{name: "wrapped public", err: Public(internal, ErrUnavailable), exp: ErrUnavailable},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			client := dial(t, startServer(t, &errHandler{err: tc.err}, testTimeouts))
+			_, err := client.Phrase(context.Background(), PhraseRequest{})
+			require.ErrorIs(t, err, tc.exp)
+			assert.NotContains(t, err.Error(), internal.Error())
+
+			var errRes *ErrorResponse
+			require.ErrorAs(t, err, &errRes)
+			assert.Equal(t, tc.exp == ErrInternal, errRes.CorrelationID != "")
+		})
+	}
+}
+
+func TestServer_MalformedFrame(t *testing.T) {
+	srv := startServer(t, &errHandler{err: ErrNotFound}, testTimeouts)
+	for _, tc := range []struct {
+		name  string
+		frame string
+	}{
+		{name: "unknown op code", frame: `{"code":"bogus"}`},
+		{name: "invalid JSON", frame: `{"code":`},
+		{name: "invalid message", frame: `{"code":"phrase_req","message":[]}`},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			conn, err := net.Dial("tcp", srv.listeners[0].Addr)
+			require.NoError(t, err)
+			defer conn.Close()
+
+			require.NoError(t, binary.Write(conn, binary.LittleEndian, uint32(len(tc.frame))))
+			_, err = conn.Write([]byte(tc.frame))
+			require.NoError(t, err)
+			msg, err := (&codec{readLimit: DefaultRequestLen}).read(conn)
+			require.NoError(t, err)
+			require.IsType(t, new(ErrorResponse), msg)
+			assert.ErrorIs(t, msg.(*ErrorResponse), ErrBadRequest)
+			assert.Empty(t, msg.(*ErrorResponse).CorrelationID)
+		})
+	}
+}
+
+func TestServer_Timeouts(t *testing.T) {
+	timeouts := testTimeouts
+	timeouts.PoW = 100 * time.Millisecond
+	timeouts.Idle = 200 * time.Millisecond
+	srv := startServer(t, &errHandler{err: ErrNotFound}, timeouts)
+
+	t.Run("PoW expired", func(t *testing.T) {
+		conn, err := net.Dial("tcp", srv.listeners[0].Addr)
+		require.NoError(t, err)
+		defer conn.Close()
+
+		cdc := codec{readLimit: DefaultRequestLen, writeLimit: DefaultRequestLen}
+		require.NoError(t, cdc.write(conn, new(PhraseRequest)))
+		msg, err := cdc.read(conn)
+		require.NoError(t, err)
+		require.IsType(t, new(powChallengeResponse), msg)
+		msg, err = cdc.read(conn)
+		require.NoError(t, err)
+		assert.ErrorIs(t, msg.(error), ErrPoWExpired)
+	})
+
+	t.Run("idle connection is closed", func(t *testing.T) {
+		client := dial(t, srv)
+		_, err := client.Phrase(context.Background(), PhraseRequest{})
+		require.ErrorIs(t, err, ErrNotFound)
+		time.Sleep(2 * timeouts.Idle)
+		_, err = client.Phrase(context.Background(), PhraseRequest{})
+		assert.Error(t, err)
+		assert.NotErrorIs(t, err, ErrNotFound)
+	})
+
+	t.Run("keepalive holds connection", func(t *testing.T) {
+		client, err := Dial(srv.listeners[0].Addr, WithKeepalive(timeouts.Idle/4, time.Second))
+		require.NoError(t, err)
+		defer client.Close()
+		time.Sleep(2 * timeouts.Idle)
+		_, err = client.Phrase(context.Background(), PhraseRequest{})
+		assert.ErrorIs(t, err, ErrNotFound)
+	})
+
+	t.Run("keepalive is limited", func(t *testing.T) {
+		timeouts := timeouts
+		timeouts.Keepalive = 2 * timeouts.Idle
+		srv := startServer(t, &errHandler{err: ErrNotFound}, timeouts)
+		client, err := Dial(srv.listeners[0].Addr, WithKeepalive(timeouts.Idle/4, time.Second))
+		require.NoError(t, err)
+		defer client.Close()
+
+		// Granted request extends keepalive
+		time.Sleep(timeouts.Keepalive * 3 / 4)
+		_, err = client.Phrase(context.Background(), PhraseRequest{})
+		require.ErrorIs(t, err, ErrNotFound)
+		time.Sleep(timeouts.Keepalive * 3 / 4)
+		require.NoError(t, client.Ping(context.Background()))
+
+		time.Sleep(timeouts.Keepalive / 2)
+		require.Error(t, client.Ping(context.Background()))
+	})
+}
+
+func TestServer_CancelStream(t *testing.T) {
+	handler := new(infiniteHandler)
+	client := dial(t, startServer(t, handler, testTimeouts))
+
+	var count int
+	for _, err := range client.AllPhrases(context.Background()) {
+		require.NoError(t, err)
+		if count++; count == 3 {
+			break
+		}
+	}
+	assert.Eventually(t, handler.stopped.Load, time.Second, 10*time.Millisecond)
+
+	// Connection is reusable
+	res, err := client.Phrase(context.Background(), PhraseRequest{})
+	require.NoError(t, err)
+	assert.Equal(t, "phrase", res.Quote)
+}
+
+func TestClient_RequestDuringStream(t *testing.T) {
+	handler := sliceHandler{{Quote: "q1"}, {Quote: "q2"}, {Quote: "q3"}}
+	client := dial(t, startServer(t, handler, testTimeouts))
+
+	var phrases []PhraseResponse
+	for res, err := range client.AllPhrases(context.Background()) {
+		require.NoError(t, err)
+		phrases = append(phrases, *res)
+		_, err = client.Phrase(context.Background(), PhraseRequest{})
+		require.ErrorIs(t, err, ErrStreamInProgress)
+		for _, err := range client.SearchPhrases(context.Background(), SearchPhrasesRequest{}) {
+			require.ErrorIs(t, err, ErrStreamInProgress)
+		}
+	}
+	assert.Equal(t, []PhraseResponse(handler), phrases)
+
+	res, err := client.Phrase(context.Background(), PhraseRequest{})
+	require.NoError(t, err)
+	assert.Equal(t, "q1", res.Quote)
+}
+
+func TestServer_FlowControl(t *testing.T) {
+	const window = 4
+	handler := new(infiniteHandler)
+	srv := startServer(t, handler, testTimeouts)
+	client, err := Dial(srv.listeners[0].Addr, WithStreamWindow(window))
+	require.NoError(t, err)
+	defer client.Close()
+
+	var count int
+	for _, err := range client.AllPhrases(context.Background()) {
+		require.NoError(t, err)
+		if count++; count == 1 {
+			// Slow consumer: server stops writing as soon as window is exhausted
+			time.Sleep(100 * time.Millisecond)
+			// Iterator yields one more item that waits for credit
+			assert.LessOrEqual(t, handler.produced.Load(), int64(window+1))
+		}
+		if count == 5*window {
+			break
+		}
+	}
+
+	res, err := client.Phrase(context.Background(), PhraseRequest{})
+	require.NoError(t, err)
+	assert.Equal(t, "phrase", res.Quote)
+
+	t.Run("zero idle timeout", func(t *testing.T) {
+		timeouts := testTimeouts
+		timeouts.Idle = 0
+		handler := sliceHandler{{Quote: "q1"}, {Quote: "q2"}, {Quote: "q3"}, {Quote: "q4"}, {Quote: "q5"}}
+		srv := startServer(t, handler, timeouts)
+		client, err := Dial(srv.listeners[0].Addr, WithStreamWindow(2))
+		require.NoError(t, err)
+		defer client.Close()
+
+		var phrases []PhraseResponse
+		for res, err := range client.AllPhrases(context.Background()) {
+			require.NoError(t, err)
+			phrases = append(phrases, *res)
+			time.Sleep(50 * time.Millisecond)
+		}
+		assert.Equal(t, []PhraseResponse(handler), phrases)
+	})
+}
+
+func TestServer_Batch(t *testing.T) {
+	var handler sliceHandler
+	for i := range 25 {
+		handler = append(handler, PhraseResponse{Quote: strings.Repeat("q", 10*i), Author: strconv.Itoa(i)})
+	}
+	srv := startServer(t, handler, testTimeouts)
+
+	for _, opts := range [][]ClientOption{
+		{WithStreamBatch(10)},
+		{WithStreamBatch(10), WithStreamWindow(2)},
+		{WithStreamBatch(1000)},
+	} {
+		client, err := Dial(srv.listeners[0].Addr, opts...)
+		require.NoError(t, err)
+
+		var phrases sliceHandler
+		for res, err := range client.AllPhrases(context.Background()) {
+			require.NoError(t, err)
+			phrases = append(phrases, *res)
+		}
+		assert.Equal(t, handler, phrases)
+		require.NoError(t, client.Close())
+	}
+}
+
+func TestServer_MessageLimits(t *testing.T) {
+	phrase := PhraseResponse{Quote: strings.Repeat("q", 2*DefaultRequestLen)}
+	srv := startServer(t, sliceHandler{phrase}, testTimeouts)
+
+	t.Run("negotiated", func(t *testing.T) {
+		client := dial(t, srv)
+		res, err := client.Phrase(context.Background(), PhraseRequest{})
+		require.NoError(t, err)
+		assert.Equal(t, phrase, *res)
+	})
+
+	t.Run("exceeded", func(t *testing.T) {
+		client, err := Dial(srv.listeners[0].Addr, WithMaxMessageLen(DefaultRequestLen))
+		require.NoError(t, err)
+		defer client.Close()
+		_, err = client.Phrase(context.Background(), PhraseRequest{})
+		assert.ErrorIs(t, err, ErrMessageTooLarge)
+	})
+
+	t.Run("version mismatch", func(t *testing.T) {
+		conn, err := net.Dial("tcp", srv.listeners[0].Addr)
+		require.NoError(t, err)
+		defer conn.Close()
+
+		cdc := codec{readLimit: DefaultRequestLen, writeLimit: DefaultRequestLen}
+		require.NoError(t, cdc.write(conn, &helloRequest{Version: protocolVersion + 1}))
+		msg, err := cdc.read(conn)
+		require.NoError(t, err)
+		assert.ErrorIs(t, msg.(error), ErrVersionMismatch)
+	})
+}
+
+func TestServer_Compression(t *testing.T) {
+	var handler sliceHandler
+	for i := range 50 {
+		handler = append(handler, PhraseResponse{Quote: strings.Repeat("wisdom ", 20), Author: strconv.Itoa(i)})
+	}
+	srv := startServer(t, handler, testTimeouts, WithCompressionThreshold(64))
+
+	for _, opts := range [][]ClientOption{
+		{WithCompression()},
+		{WithCompression(), WithStreamBatch(20)},
+		// Compression isn't negotiated
+		{WithStreamBatch(20)},
+	} {
+		client, err := Dial(srv.listeners[0].Addr, opts...)
+		require.NoError(t, err)
+
+		res, err := client.Phrase(context.Background(), PhraseRequest{})
+		require.NoError(t, err)
+		assert.Equal(t, handler[0], *res)
+
+		var phrases sliceHandler
+		for res, err := range client.AllPhrases(context.Background()) {
+			require.NoError(t, err)
+			phrases = append(phrases, *res)
+		}
+		assert.Equal(t, handler, phrases)
+		require.NoError(t, client.Close())
+	}
+}
+
+func TestServer_WebSocket(t *testing.T) {
+	handler := sliceHandler{{Quote: "q1", Author: "a1"}, {Quote: "q2", Author: "a2"}}
+	puzzle, err := pow.NewPuzzle(func(uint) uint { return 1 })
+	require.NoError(t, err)
+	srv := NewServer("", testTimeouts, handler, puzzle, WithCompressionThreshold(1))
+	httpSrv := httptest.NewServer(http.HandlerFunc(srv.ServeWebSocket))
+	defer httpSrv.Close()
+
+	client, err := DialWebSocket(context.Background(), "ws"+strings.TrimPrefix(httpSrv.URL, "http"), WithCompression())
+	require.NoError(t, err)
+	defer client.Close()
+
+	res, err := client.Phrase(context.Background(), PhraseRequest{})
+	require.NoError(t, err)
+	assert.Equal(t, handler[0], *res)
+
+	var phrases sliceHandler
+	for res, err := range client.AllPhrases(context.Background()) {
+		require.NoError(t, err)
+		phrases = append(phrases, *res)
+	}
+	assert.Equal(t, handler, phrases)
+	require.NoError(t, client.Ping(context.Background()))
+}
+
+func TestServer_UnixSocket(t *testing.T) {
+	dir, err := os.MkdirTemp("", "wow")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "wow.sock")
+
+	var challenges atomic.Int32
+	puzzle, err := pow.NewPuzzle(func(uint) uint {
+		challenges.Add(1)
+		return 1
+	})
+	require.NoError(t, err)
+	handler := sliceHandler{{Quote: "q1", Author: "a1"}}
+	srv := NewServer(unixScheme+path, testTimeouts, handler, puzzle, WithUnixSocketMode(0o600), WithLocalPoWBypass())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- srv.Listen(ctx) }()
+	defer func() {
+		cancel()
+		assert.NoError(t, <-done)
+	}()
+
+	var client *Client
+	require.Eventually(t, func() bool {
+		client, err = Dial(unixScheme + path)
+		return err == nil
+	}, time.Second, 10*time.Millisecond)
+	defer client.Close()
+
+	info, err := os.Stat(path)
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0o600), info.Mode().Perm())
+
+	res, err := client.Phrase(context.Background(), PhraseRequest{})
+	require.NoError(t, err)
+	assert.Equal(t, handler[0], *res)
+	assert.Zero(t, challenges.Load())
+}
+
+func TestServer_SearchPhrases(t *testing.T) {
+	handler := sliceHandler{
+		{Quote: "q1", Author: "a1"},
+		{Quote: "q2", Author: "a2"},
+		{Quote: "q3", Author: "a1"},
+		{Quote: "q4", Author: "a1"},
+	}
+	srv := startServer(t, handler, testTimeouts)
+	client := dial(t, srv)
+
+	var phrases []PhraseResponse
+	for res, err := range client.SearchPhrases(context.Background(), SearchPhrasesRequest{Author: "a1", Limit: 2}) {
+		require.NoError(t, err)
+		phrases = append(phrases, *res)
+	}
+	assert.Equal(t, []PhraseResponse{handler[0], handler[2]}, phrases)
+
+	// Connection is reusable after search
+	res, err := client.Phrase(context.Background(), PhraseRequest{})
+	require.NoError(t, err)
+	assert.Equal(t, handler[0], *res)
+}
+
+func TestServer_GetPhrase(t *testing.T) {
+	handler := sliceHandler{
+		{ID: "1", Quote: "q1", Author: "a1"},
+		{ID: "2", Quote: "q2", Author: "a2"},
+	}
+	srv := startServer(t, handler, testTimeouts)
+	client := dial(t, srv)
+
+	res, err := client.GetPhrase(context.Background(), "2")
+	require.NoError(t, err)
+	assert.Equal(t, handler[1], *res)
+
+	_, err = client.GetPhrase(context.Background(), "3")
+	require.ErrorIs(t, err, ErrNotFound)
+}
+
+func TestServer_Pagination(t *testing.T) {
+	handler := sliceHandler{
+		{Quote: "q1", Author: "a1"},
+		{Quote: "q2", Author: "a2"},
+		{Quote: "q3", Author: "a3"},
+	}
+	srv := startServer(t, handler, testTimeouts)
+	client := dial(t, srv)
+
+	var phrases []PhraseResponse
+	var pages int
+	for cursor := ""; pages == 0 || cursor != ""; pages++ {
+		page, next, err := client.PhrasesPage(context.Background(), cursor, 2)
+		require.NoError(t, err)
+		phrases = append(phrases, page...)
+		cursor = next
+	}
+	assert.Equal(t, 2, pages)
+	assert.Equal(t, []PhraseResponse(handler), phrases)
+}
+
+func TestServer_Listeners(t *testing.T) {
+	var challenges atomic.Int32
+	puzzle, err := pow.NewPuzzle(func(uint) uint {
+		challenges.Add(1)
+		return 1
+	})
+	require.NoError(t, err)
+	cert, roots := selfSignedCert(t)
+	tlsAddr, limitedAddr := freeAddr(t), freeAddr(t)
+	handler := sliceHandler{{Quote: "q1", Author: "a1"}}
+	startServer(t, handler, testTimeouts, WithListeners(
+		Listener{Addr: tlsAddr, TLS: &tls.Config{Certificates: []tls.Certificate{cert}}, PoWBypass: true},
+		Listener{Addr: limitedAddr, Puzzle: puzzle, MaxConns: 1},
+	))
+
+	t.Run("tls without PoW", func(t *testing.T) {
+		var client *Client
+		require.Eventually(t, func() bool {
+			client, err = DialTLS(tlsAddr, &tls.Config{RootCAs: roots})
+			return err == nil
+		}, time.Second, 10*time.Millisecond)
+		defer client.Close()
+
+		res, err := client.Phrase(context.Background(), PhraseRequest{})
+		require.NoError(t, err)
+		assert.Equal(t, handler[0], *res)
+		assert.Zero(t, challenges.Load())
+	})
+
+	t.Run("own puzzle and connection limit", func(t *testing.T) {
+		var client *Client
+		require.Eventually(t, func() bool {
+			client, err = Dial(limitedAddr)
+			return err == nil
+		}, time.Second, 10*time.Millisecond)
+		defer client.Close()
+
+		res, err := client.Phrase(context.Background(), PhraseRequest{})
+		require.NoError(t, err)
+		assert.Equal(t, handler[0], *res)
+		assert.EqualValues(t, 1, challenges.Load())
+
+		conn, err := net.Dial("tcp", limitedAddr)
+		require.NoError(t, err)
+		defer conn.Close()
+		msg, err := (&codec{readLimit: DefaultRequestLen}).read(conn)
+		require.NoError(t, err)
+		require.IsType(t, new(ErrorResponse), msg)
+		assert.ErrorIs(t, msg.(*ErrorResponse), ErrUnavailable)
+	})
+
+	t.Run("silent tls client over connection limit", func(t *testing.T) {
+		timeouts := testTimeouts
+		timeouts.Read = 100 * time.Millisecond
+		addr := freeAddr(t)
+		srv := startServer(t, handler, timeouts, WithListeners(
+			Listener{Addr: addr, TLS: &tls.Config{Certificates: []tls.Certificate{cert}}, MaxConns: 1},
+		))
+		var client *Client
+		require.Eventually(t, func() bool {
+			client, err = DialTLS(addr, &tls.Config{RootCAs: roots})
+			return err == nil
+		}, time.Second, 10*time.Millisecond)
+		defer client.Close()
+
+		conn, err := net.Dial("tcp", addr)
+		require.NoError(t, err)
+		defer conn.Close()
+		require.NoError(t, conn.SetReadDeadline(time.Now().Add(10*timeouts.Read)))
+		_, err = conn.Read(make([]byte, 1))
+		require.Error(t, err)
+		assert.False(t, isTimeout(err), "connection isn't closed by server: %v", err)
+		assert.Eventually(t, func() bool { return srv.listeners[1].conns.Load() == 1 }, time.Second, 10*time.Millisecond)
+	})
+}
+
+func TestServer_Admin(t *testing.T) {
+	cert, roots := selfSignedCert(t)
+	tlsAddr := freeAddr(t)
+	srv := startServer(t, sliceHandler{{ID: "1"}}, testTimeouts,
+		WithAdmin(Admin{Handler: new(adminHandler), Tokens: []string{"secret"}, Identities: []string{"wow"}}),
+		WithListeners(Listener{Addr: tlsAddr, TLS: &tls.Config{
+			Certificates: []tls.Certificate{cert},
+			ClientCAs:    roots,
+			ClientAuth:   tls.VerifyClientCertIfGiven,
+		}}),
+	)
+	ctx := context.Background()
+
+	t.Run("forbidden", func(t *testing.T) {
+		client := dial(t, srv)
+		_, err := client.CreatePhrase(ctx, CreatePhraseRequest{Quote: "q", Author: "a"})
+		require.ErrorIs(t, err, ErrForbidden)
+
+		// Connection is reusable
+		_, err = client.Phrase(ctx, PhraseRequest{})
+		require.NoError(t, err)
+
+		_, err = Dial(srv.listeners[0].Addr, WithAdminToken("wrong"))
+		require.ErrorIs(t, err, ErrForbidden)
+	})
+
+	t.Run("token", func(t *testing.T) {
+		client, err := Dial(srv.listeners[0].Addr, WithAdminToken("secret"))
+		require.NoError(t, err)
+		defer client.Close()
+
+		res, err := client.CreatePhrase(ctx, CreatePhraseRequest{Quote: "q", Author: "a"})
+		require.NoError(t, err)
+		assert.Equal(t, PhraseResponse{ID: "1", Quote: "q", Author: "a"}, *res)
+		res, err = client.UpdatePhrase(ctx, UpdatePhraseRequest{ID: "1", Quote: "q2", Author: "a2"})
+		require.NoError(t, err)
+		assert.Equal(t, PhraseResponse{ID: "1", Quote: "q2", Author: "a2"}, *res)
+		require.NoError(t, client.DeletePhrase(ctx, "1"))
+		require.ErrorIs(t, client.DeletePhrase(ctx, "2"), ErrNotFound)
+
+		// Write requests exceed DefaultRequestLen
+		res, err = client.CreatePhrase(ctx, longPhrase)
+		require.NoError(t, err)
+		assert.Equal(t, longPhrase.Quote, res.Quote)
+	})
+
+	t.Run("client certificate", func(t *testing.T) {
+		var client *Client
+		require.Eventually(t, func() bool {
+			var err error
+			client, err = DialTLS(tlsAddr, &tls.Config{Certificates: []tls.Certificate{cert}, RootCAs: roots})
+			return err == nil
+		}, time.Second, 10*time.Millisecond)
+		defer client.Close()
+
+		require.NoError(t, client.DeletePhrase(ctx, "1"))
+		_, err := client.CreatePhrase(ctx, longPhrase)
+		require.NoError(t, err)
+	})
+}
+
+// longPhrase is a phrase of the longest quote allowed by server's repository
+var longPhrase = CreatePhraseRequest{
+	Quote:        strings.Repeat("ж", 1000),
+	Author:       "a",
+	Translations: map[string]Translation{"en": {Quote: strings.Repeat("q", 1000), Author: "a"}},
+}
+
+func TestIP(t *testing.T) {
+	for _, tc := range []struct {
+		addr net.Addr
+		exp  net.IP
+	}{
+		{addr: &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1)}, exp: net.IPv4(127, 0, 0, 1)},
+		{addr: &net.UnixAddr{Name: "/tmp/wow.sock", Net: "unix"}, exp: nil},
+		{addr: nil, exp: nil},
+	} {
+		assert.Equal(t, tc.exp, ip(&addrConn{remote: tc.addr}))
+	}
+}
+
+var testTimeouts = Timeouts{
+	Read:  time.Second,
+	PoW:   5 * time.Second,
+	Write: time.Second,
+	Idle:  5 * time.Second,
+}
+
+type panicHandler struct{}
+
+func (*panicHandler) Phrase(context.Context, *PhraseRequest) (*PhraseResponse, error) {
+	panic("phrase")
+}
+
+func (*panicHandler) GetPhrase(context.Context, *GetPhraseRequest) (*PhraseResponse, error) {
+	panic("get phrase")
+}
+
+func (*panicHandler) AllPhrases(context.Context, *AllPhrasesRequest) Page[*PhraseResponse] {
+	return Page[*PhraseResponse]{Items: func(func(*PhraseResponse, error) bool) { panic("all phrases") }}
+}
+
+func (*panicHandler) SearchPhrases(context.Context, *SearchPhrasesRequest) iter.Seq2[*PhraseResponse, error] {
+	return func(func(*PhraseResponse, error) bool) { panic("search phrases") }
+}
+
+type errHandler struct {
+	err error
+}
+
+func (h *errHandler) Phrase(context.Context, *PhraseRequest) (*PhraseResponse, error) {
+	return nil, h.err
+}
+
+func (h *errHandler) GetPhrase(context.Context, *GetPhraseRequest) (*PhraseResponse, error) {
+	return nil, h.err
+}
+
+func (h *errHandler) AllPhrases(context.Context, *AllPhrasesRequest) Page[*PhraseResponse] {
+	return Page[*PhraseResponse]{Items: func(yield func(*PhraseResponse, error) bool) { yield(nil, h.err) }}
+}
+
+func (h *errHandler) SearchPhrases(context.Context, *SearchPhrasesRequest) iter.Seq2[*PhraseResponse, error] {
+	return func(yield func(*PhraseResponse, error) bool) { yield(nil, h.err) }
+}
+
+// infiniteHandler streams phrases until the stream is canceled
+type infiniteHandler struct {
+	produced atomic.Int64
+	stopped  atomic.Bool
+}
+
+func (*infiniteHandler) Phrase(context.Context, *PhraseRequest) (*PhraseResponse, error) {
+	return &PhraseResponse{Quote: "phrase"}, nil
+}
+
+func (*infiniteHandler) GetPhrase(_ context.Context, req *GetPhraseRequest) (*PhraseResponse, error) {
+	return &PhraseResponse{ID: req.ID, Quote: "phrase"}, nil
+}
+
+func (h *infiniteHandler) AllPhrases(ctx context.Context, _ *AllPhrasesRequest) Page[*PhraseResponse] {
+	return Page[*PhraseResponse]{Items: h.SearchPhrases(ctx, nil)}
+}
+
+func (h *infiniteHandler) SearchPhrases(context.Context, *SearchPhrasesRequest) iter.Seq2[*PhraseResponse, error] {
+	return func(yield func(*PhraseResponse, error) bool) {
+		defer h.stopped.Store(true)
+		for {
+			h.produced.Add(1)
+			if !yield(&PhraseResponse{Quote: "phrase"}, nil) {
+				return
+			}
+		}
+	}
+}
+
+type sliceHandler []PhraseResponse
+
+func (h sliceHandler) Phrase(context.Context, *PhraseRequest) (*PhraseResponse, error) {
+	return &h[0], nil
+}
+
+func (h sliceHandler) GetPhrase(_ context.Context, req *GetPhraseRequest) (*PhraseResponse, error) {
+	for _, phrase := range h {
+		if phrase.ID == req.ID {
+			return &phrase, nil
+		}
+	}
+	return nil, ErrNotFound
+}
+
+// AllPhrases uses index of phrase as cursor
+func (h sliceHandler) AllPhrases(_ context.Context, req *AllPhrasesRequest) Page[*PhraseResponse] {
+	var from int
+	if req.Cursor != "" {
+		from, _ = strconv.Atoi(req.Cursor)
+	}
+	to := len(h)
+	if req.PageSize > 0 {
+		to = min(from+int(req.PageSize), to)
+	}
+	return Page[*PhraseResponse]{
+		Items: func(yield func(*PhraseResponse, error) bool) {
+			for _, phrase := range h[from:to] {
+				if !yield(&phrase, nil) {
+					return
+				}
+			}
+		},
+		Next: func() string {
+			if to == len(h) {
+				return ""
+			}
+			return strconv.Itoa(to)
+		},
+	}
+}
+
+// SearchPhrases filters phrases by exact author
+func (h sliceHandler) SearchPhrases(_ context.Context, req *SearchPhrasesRequest) iter.Seq2[*PhraseResponse, error] {
+	return func(yield func(*PhraseResponse, error) bool) {
+		var n uint
+		for _, phrase := range h {
+			if req.Limit > 0 && n >= req.Limit {
+				return
+			}
+			if req.Author != "" && phrase.Author != req.Author {
+				continue
+			}
+			n++
+			if !yield(&phrase, nil) {
+				return
+			}
+		}
+	}
+}
+
+// adminHandler assigns ID "1" to every created phrase
+type adminHandler struct{}
+
+func (*adminHandler) CreatePhrase(_ context.Context, req *CreatePhraseRequest) (*PhraseResponse, error) {
+	return &PhraseResponse{ID: "1", Quote: req.Quote, Author: req.Author}, nil
+}
+
+func (*adminHandler) UpdatePhrase(_ context.Context, req *UpdatePhraseRequest) (*PhraseResponse, error) {
+	return &PhraseResponse{ID: req.ID, Quote: req.Quote, Author: req.Author}, nil
+}
+
+func (*adminHandler) DeletePhrase(_ context.Context, req *DeletePhraseRequest) error {
+	if req.ID != "1" {
+		return ErrNotFound
+	}
+	return nil
+}
+
+type addrConn struct {
+	net.Conn
+	remote net.Addr
+}
+
+func (c *addrConn) RemoteAddr() net.Addr { return c.remote }
+
+// startServer listens on random local port until the test is finished
+func startServer(t *testing.T, handler ServerHandler, timeouts Timeouts, opts ...ServerOption) *Server {
+	t.Helper()
+	addr := freeAddr(t)
+	puzzle, err := pow.NewPuzzle(func(uint) uint { return 1 })
+	require.NoError(t, err)
+	srv := NewServer(addr, timeouts, handler, puzzle, opts...)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- srv.Listen(ctx) }()
+	t.Cleanup(func() {
+		cancel()
+		assert.NoError(t, <-done)
+	})
+	require.Eventually(t, func() bool {
+		conn, err := net.Dial("tcp", addr)
+		if err != nil {
+			return false
+		}
+		_ = conn.Close()
+		return true
+	}, time.Second, 10*time.Millisecond)
+	return srv
+}
+
+// freeAddr returns local TCP address that isn't in use
+func freeAddr(t *testing.T) string {
+	t.Helper()
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer lis.Close()
+	return lis.Addr().String()
+}
+
+// selfSignedCert generates certificate of 127.0.0.1 (common name "wow") and pool of roots trusting it
+func selfSignedCert(t *testing.T) (tls.Certificate, *x509.CertPool) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "wow"},
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1)},
+		NotBefore:    time.Now().Add(-time.Minute),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	require.NoError(t, err)
+	leaf, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+	roots := x509.NewCertPool()
+	roots.AddCert(leaf)
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key, Leaf: leaf}, roots
+}
+
+func dial(t *testing.T, srv *Server) *Client {
+	t.Helper()
+	client, err := Dial(srv.listeners[0].Addr)
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = client.Close() })
+	return client
+}
@@ -0,0 +1,221 @@
+package api
+
+import (
+	"encoding/json"
+	"iter"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/egsam98/errors"
+)
+
+var errStreamCanceled = errors.New("stream is canceled by client")
+
+// StreamRequest is embedded into requests that are responded with stream
+type StreamRequest struct {
+	// Window is a number of frames server may write until client grants more credits via streamCreditRequest.
+	// Zero value disables flow control
+	Window uint `json:"window,omitempty"`
+	// BatchSize is a maximum number of items packed into single frame (see streamBatchResponse).
+	// Values less than 2 disable batching
+	BatchSize uint `json:"batch_size,omitempty"`
+}
+
+func (r *StreamRequest) stream() *StreamRequest { return r }
+
+// Page is a stream of items resumable from cursor
+type Page[T any] struct {
+	Items iter.Seq2[T, error]
+	// Next is called once Items are consumed. It returns opaque cursor of the next page, empty cursor means the last page
+	Next func() string
+}
+
+type streamMessage interface {
+	message
+	stream() *StreamRequest
+}
+
+// streamControl reads client's control messages while server is streaming response:
+// - cancelStreamRequest stops the stream
+// - streamCreditRequest grants more frames to write
+// The client isn't allowed to send anything else until stream's tombstone is received
+type streamControl struct {
+	conn *serverConn
+	// limited is true if flow control is enabled
+	limited bool
+	// notify wakes up the writer waiting for credits
+	notify   chan struct{}
+	handover chan readResult
+
+	mu       sync.Mutex
+	credits  uint
+	canceled bool
+	err      error
+	detached bool
+}
+
+func controlStream(conn *serverConn, window uint) *streamControl {
+	sc := &streamControl{
+		conn:     conn,
+		limited:  window > 0,
+		credits:  window,
+		notify:   make(chan struct{}, 1),
+		handover: make(chan readResult, 1),
+	}
+	// Stream duration is limited by write timeouts and credits waiting only
+	_ = conn.SetReadDeadline(time.Time{})
+	go sc.run()
+	return sc
+}
+
+func (sc *streamControl) run() {
+	for {
+		msg, err := sc.conn.read()
+
+		sc.mu.Lock()
+		if sc.detached || err != nil {
+			sc.err = err
+			sc.mu.Unlock()
+			sc.wake()
+			sc.handover <- readResult{msg: msg, err: err}
+			return
+		}
+		switch msg := msg.(type) {
+		case *cancelStreamRequest:
+			sc.canceled = true
+		case *streamCreditRequest:
+			sc.credits += msg.Credits
+		default:
+			// Protocol violation: the message is handled as the next request after the stream
+			sc.mu.Unlock()
+			sc.handover <- readResult{msg: msg}
+			return
+		}
+		sc.mu.Unlock()
+		sc.wake()
+	}
+}
+
+func (sc *streamControl) wake() {
+	select {
+	case sc.notify <- struct{}{}:
+	default:
+	}
+}
+
+// acquire takes credit to write the next frame waiting for it at most `timeout`. Zero timeout waits without limit.
+// Errors:
+// - errStreamCanceled if client canceled the stream
+// - os.ErrDeadlineExceeded if credit isn't granted in time
+// - reading error
+func (sc *streamControl) acquire(timeout time.Duration) error {
+	var expired <-chan time.Time
+	if timeout > 0 {
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		expired = timer.C
+	}
+	for {
+		sc.mu.Lock()
+		switch {
+		case sc.canceled:
+			sc.mu.Unlock()
+			return errStreamCanceled
+		case sc.err != nil:
+			sc.mu.Unlock()
+			return sc.err
+		case !sc.limited:
+			sc.mu.Unlock()
+			return nil
+		case sc.credits > 0:
+			sc.credits--
+			sc.mu.Unlock()
+			return nil
+		}
+		sc.mu.Unlock()
+
+		select {
+		case <-sc.notify:
+		case <-expired:
+			return errors.Wrap(os.ErrDeadlineExceeded, "await stream credits")
+		}
+	}
+}
+
+// stop detaches streamControl from connection. The message being read is taken over by the next request reading
+func (sc *streamControl) stop() {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	if sc.detached {
+		return
+	}
+	sc.detached = true
+	sc.conn.pending = sc.handover
+}
+
+// batchOverhead is reserved for JSON envelope of streamBatchResponse in operation
+const batchOverhead = 128
+
+// streamBatch packs stream items into streamBatchResponse frames that fit `limit`
+type streamBatch struct {
+	size  uint
+	limit int
+	emit  func(message) error
+	frame streamBatchResponse
+	bytes int
+}
+
+func newStreamBatch(size uint, limit uint32, emit func(message) error) *streamBatch {
+	return &streamBatch{size: size, limit: int(limit), emit: emit}
+}
+
+// add item to batch emitting the frame once it's full. The item is emitted as is if batching is disabled
+func (b *streamBatch) add(msg message) error {
+	if b.size < 2 {
+		return b.emit(msg)
+	}
+	item, err := json.Marshal(msg)
+	if err != nil {
+		return errors.Wrap(err, "marshal %+v", msg)
+	}
+	if len(b.frame.Items) > 0 && b.bytes+len(item)+1 > b.limit-batchOverhead {
+		if err := b.flush(); err != nil {
+			return err
+		}
+	}
+	b.frame.Code = msg.opCode()
+	b.frame.Items = append(b.frame.Items, item)
+	b.bytes += len(item) + 1
+	if uint(len(b.frame.Items)) >= b.size {
+		return b.flush()
+	}
+	return nil
+}
+
+// flush emits incomplete frame
+func (b *streamBatch) flush() error {
+	if len(b.frame.Items) == 0 {
+		return nil
+	}
+	frame := b.frame
+	b.frame, b.bytes = streamBatchResponse{}, 0
+	return b.emit(&frame)
+}
+
+// unbatch decodes items of streamBatchResponse
+func unbatch[Out message](batch *streamBatchResponse) ([]Out, error) {
+	items := make([]Out, len(batch.Items))
+	for i, raw := range batch.Items {
+		msg, err := decode(batch.Code, raw)
+		if err != nil {
+			return nil, err
+		}
+		item, ok := msg.(Out)
+		if !ok {
+			return nil, errors.Errorf("unexpected stream batch item %#v", msg)
+		}
+		items[i] = item
+	}
+	return items, nil
+}
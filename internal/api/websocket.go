@@ -0,0 +1,33 @@
+package api
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/coder/websocket"
+	"github.com/egsam98/errors"
+	"github.com/rs/zerolog/log"
+)
+
+// wsPath is a path of WebSocket endpoint served by Server.ListenWebSocket
+const wsPath = "/ws"
+
+// ServeWebSocket upgrades HTTP request to WebSocket connection and serves it the same way as TCP one, i.e. with
+// the same handshake, PoW and handlers. Every binary WebSocket message carries single frame: 4-byte little-endian
+// header (body length and flags) followed by `operation` JSON
+func (s *Server) ServeWebSocket(w http.ResponseWriter, r *http.Request) {
+	ws, err := websocket.Accept(w, r, &websocket.AcceptOptions{OriginPatterns: s.wsOrigins})
+	if err != nil {
+		log.Debug().Err(err).Str("from", r.RemoteAddr).Msg("Accept WebSocket connection")
+		return
+	}
+	s.handle(r.Context(), websocket.NetConn(r.Context(), ws, websocket.MessageBinary), s.puzzle)
+}
+
+// ListenWebSocket accepts WebSocket connections on `addr` at /ws path.
+// The method blocks until the context is canceled
+func (s *Server) ListenWebSocket(ctx context.Context, addr string) error {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET "+wsPath, s.ServeWebSocket)
+	return errors.Wrap(listenHTTP(ctx, addr, mux), "listen WebSocket server")
+}